@@ -1,9 +1,18 @@
 package context
 
 import (
+	gocontext "context"
 	"fmt"
+	"strings"
+	"time"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/pkg/errors"
+	cmn "github.com/tendermint/tendermint/libs/common"
+	rpcclient "github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	rpctypes "github.com/tendermint/tendermint/rpc/lib/types"
+	tmtypes "github.com/tendermint/tendermint/types"
 )
 
 // BroadcastTx broadcasts a transactions either synchronously or asynchronously
@@ -21,8 +30,11 @@ func (ctx Context) BroadcastTx(txBytes []byte) (res sdk.TxResponse, err error) {
 	case BroadcastBlock:
 		res, err = ctx.BroadcastTxCommit(txBytes)
 
+	case BroadcastWait:
+		res, err = ctx.BroadcastTxWait(txBytes)
+
 	default:
-		return sdk.TxResponse{}, fmt.Errorf("unsupported return type %s; supported types: sync, async, block", ctx.BroadcastMode)
+		return sdk.TxResponse{}, fmt.Errorf("unsupported return type %s; supported types: sync, async, block, wait", ctx.BroadcastMode)
 	}
 
 	return res, err
@@ -33,16 +45,15 @@ func (ctx Context) BroadcastTx(txBytes []byte) (res sdk.TxResponse, err error) {
 //
 // NOTE: This should ideally not be used as the request may timeout but the tx
 // may still be included in a block. Use BroadcastTxAsync or BroadcastTxSync
-// instead.
+// instead, or BroadcastTxWait.
 func (ctx Context) BroadcastTxCommit(txBytes []byte) (sdk.TxResponse, error) {
-	node, err := ctx.GetNode()
-	if err != nil {
-		return sdk.TxResponse{}, err
-	}
-
-	res, err := node.BroadcastTxCommit(txBytes)
+	var res *ctypes.ResultBroadcastTxCommit
+	err := ctx.withNode(broadcastRequest, func(node rpcclient.Client) (err error) {
+		res, err = node.BroadcastTxCommit(txBytes)
+		return err
+	})
 	if err != nil {
-		return sdk.NewResponseFormatBroadcastTxCommit(res), err
+		return failedTxResponse(sdk.NewResponseFormatBroadcastTxCommit(res), txBytes, err), err
 	}
 
 	if !res.CheckTx.IsOK() {
@@ -59,23 +70,154 @@ func (ctx Context) BroadcastTxCommit(txBytes []byte) (sdk.TxResponse, error) {
 // BroadcastTxSync broadcasts transaction bytes to a Tendermint node
 // synchronously (i.e. returns after CheckTx execution).
 func (ctx Context) BroadcastTxSync(txBytes []byte) (sdk.TxResponse, error) {
-	node, err := ctx.GetNode()
-	if err != nil {
-		return sdk.TxResponse{}, err
-	}
-
-	res, err := node.BroadcastTxSync(txBytes)
-	return sdk.NewResponseFormatBroadcastTx(res), err
+	var res *ctypes.ResultBroadcastTx
+	err := ctx.withNode(broadcastRequest, func(node rpcclient.Client) (err error) {
+		res, err = node.BroadcastTxSync(txBytes)
+		return err
+	})
+	return failedTxResponse(sdk.NewResponseFormatBroadcastTx(res), txBytes, err), err
 }
 
 // BroadcastTxAsync broadcasts transaction bytes to a Tendermint node
 // asynchronously (i.e. returns immediately).
 func (ctx Context) BroadcastTxAsync(txBytes []byte) (sdk.TxResponse, error) {
-	node, err := ctx.GetNode()
+	var res *ctypes.ResultBroadcastTx
+	err := ctx.withNode(broadcastRequest, func(node rpcclient.Client) (err error) {
+		res, err = node.BroadcastTxAsync(txBytes)
+		return err
+	})
+	return failedTxResponse(sdk.NewResponseFormatBroadcastTx(res), txBytes, err), err
+}
+
+// failedTxResponse returns the response of a broadcast that failed with err.
+// If the connection failed after the transaction was sent, it may still be
+// committed, so the response gets its hash to look it up.
+func failedTxResponse(res sdk.TxResponse, txBytes []byte, err error) sdk.TxResponse {
+	if res.TxHash == "" && isConnectionError(err) && !isDialError(err) {
+		res.TxHash = cmn.HexBytes(tmtypes.Tx(txBytes).Hash()).String()
+	}
+
+	return res
+}
+
+// BroadcastTxWait broadcasts transaction bytes synchronously and then polls
+// the node until the transaction is committed, returning its DeliverTx
+// result. Unlike BroadcastTxCommit it doesn't keep a request open, and if the
+// transaction isn't committed within BroadcastTimeout or BroadcastMaxBlocks
+// blocks it returns a *TxTimeoutError with the hash to keep tracking it.
+func (ctx Context) BroadcastTxWait(txBytes []byte) (sdk.TxResponse, error) {
+	return ctx.BroadcastTxWaitContext(gocontext.Background(), txBytes)
+}
+
+// BroadcastTxWaitContext is BroadcastTxWait that stops waiting with the
+// error of goCtx when it is done; the response still has the tx hash.
+func (ctx Context) BroadcastTxWaitContext(goCtx gocontext.Context, txBytes []byte) (sdk.TxResponse, error) {
+	res, err := ctx.BroadcastTxSync(txBytes)
 	if err != nil {
-		return sdk.TxResponse{}, err
+		return res, err
+	}
+	if res.Code != 0 {
+		return res, errors.New(res.RawLog)
+	}
+
+	return ctx.WaitForTxContext(goCtx, tmtypes.Tx(txBytes).Hash())
+}
+
+// WaitForTx polls the node until the transaction with the given hash is
+// committed and returns its DeliverTx result. The node must index
+// transactions. It gives up with a *TxTimeoutError like BroadcastTxWait.
+func (ctx Context) WaitForTx(hash cmn.HexBytes) (sdk.TxResponse, error) {
+	return ctx.WaitForTxContext(gocontext.Background(), hash)
+}
+
+// WaitForTxContext is WaitForTx that stops waiting with the error of goCtx
+// when it is done
+func (ctx Context) WaitForTxContext(goCtx gocontext.Context, hash cmn.HexBytes) (sdk.TxResponse, error) {
+	timeout := ctx.BroadcastTimeout
+	if timeout <= 0 {
+		timeout = DefaultBroadcastTimeout
+	}
+	interval := ctx.BroadcastPollInterval
+	if interval <= 0 {
+		interval = DefaultBroadcastPollInterval
+	}
+
+	deadline := time.Now().Add(timeout)
+	timeoutErr := &TxTimeoutError{TxHash: hash.String()}
+
+	var (
+		maxHeight int64
+		err       error
+	)
+	if ctx.BroadcastMaxBlocks > 0 {
+		if timeoutErr.Height, err = ctx.latestHeight(); err != nil {
+			return sdk.TxResponse{TxHash: hash.String()}, err
+		}
+		maxHeight = timeoutErr.Height + ctx.BroadcastMaxBlocks
+	}
+
+	for {
+		var res *ctypes.ResultTx
+		err := ctx.withNode(queryRequest, func(node rpcclient.Client) (err error) {
+			res, err = node.Tx(hash, false)
+			return err
+		})
+		if err == nil {
+			resp := sdk.NewResponseResultTx(res, nil, "")
+			if !res.TxResult.IsOK() {
+				return resp, errors.New(res.TxResult.Log)
+			}
+			return resp, nil
+		}
+		notFound, indexingDisabled := txLookupError(err)
+		if indexingDisabled {
+			return sdk.TxResponse{TxHash: hash.String()}, err
+		}
+		if !notFound {
+			// the node may be temporarily unavailable
+			timeoutErr.Err = err
+		}
+
+		if maxHeight > 0 {
+			if height, err := ctx.latestHeight(); err == nil {
+				timeoutErr.Height = height
+			}
+			if timeoutErr.Height >= maxHeight {
+				return sdk.TxResponse{TxHash: hash.String()}, timeoutErr
+			}
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			if maxHeight == 0 {
+				if height, err := ctx.latestHeight(); err == nil {
+					timeoutErr.Height = height
+				}
+			}
+			return sdk.TxResponse{TxHash: hash.String()}, timeoutErr
+		}
+		if wait > interval {
+			wait = interval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-goCtx.Done():
+			timer.Stop()
+			return sdk.TxResponse{TxHash: hash.String()}, goCtx.Err()
+		}
+	}
+}
+
+// txLookupError tells whether an error of a Tx request means that the node
+// hasn't got the transaction (yet) or doesn't index transactions at all.
+// Tendermint reports both as internal errors, so the description is matched.
+func txLookupError(err error) (notFound, indexingDisabled bool) {
+	desc := err.Error()
+	if rpcErr, ok := errors.Cause(err).(*rpctypes.RPCError); ok {
+		desc = rpcErr.Data
 	}
 
-	res, err := node.BroadcastTxAsync(txBytes)
-	return sdk.NewResponseFormatBroadcastTx(res), err
+	return strings.HasSuffix(desc, "not found"), strings.Contains(desc, "indexing is disabled")
 }
@@ -0,0 +1,135 @@
+package context
+
+import (
+	gocontext "context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	abci "github.com/tendermint/tendermint/abci/types"
+	cmn "github.com/tendermint/tendermint/libs/common"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+func acceptTx(tx tmtypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+	return &ctypes.ResultBroadcastTx{Hash: tx.Hash()}, nil
+}
+
+// txAfter returns a tx func finding the transaction with result on the
+// polls after the first n
+func txAfter(n int, result abci.ResponseDeliverTx) func(hash []byte) (*ctypes.ResultTx, error) {
+	return func(hash []byte) (*ctypes.ResultTx, error) {
+		if n > 0 {
+			n--
+			return nil, errors.Wrap(rpcError("Tx (ABCD) not found"), "response error")
+		}
+		return &ctypes.ResultTx{Hash: hash, Height: 5, TxResult: result}, nil
+	}
+}
+
+func waitContext(node *mockNode) *Context {
+	return &Context{
+		Client:                node,
+		BroadcastMode:         BroadcastWait,
+		BroadcastPollInterval: time.Millisecond,
+		BroadcastTimeout:      time.Second,
+	}
+}
+
+func TestBroadcastTxWait(t *testing.T) {
+	tx := tmtypes.Tx("tx")
+	node := &mockNode{broadcastSync: acceptTx, tx: txAfter(2, abci.ResponseDeliverTx{})}
+
+	res, err := waitContext(node).BroadcastTx(tx)
+	require.Nil(t, err)
+	require.Equal(t, int64(5), res.Height)
+	require.Equal(t, cmn.HexBytes(tx.Hash()).String(), res.TxHash)
+	require.Equal(t, 1, node.count("broadcast_tx_sync"))
+	require.Equal(t, 3, node.count("tx"))
+}
+
+func TestBroadcastTxWaitFailed(t *testing.T) {
+	// logs aren't used as format strings
+	node := &mockNode{
+		broadcastSync: func(tx tmtypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+			return &ctypes.ResultBroadcastTx{Code: 4, Log: "rejected: 100%s", Hash: tx.Hash()}, nil
+		},
+	}
+	res, err := waitContext(node).BroadcastTx(tmtypes.Tx("tx"))
+	require.EqualError(t, err, "rejected: 100%s")
+	require.Equal(t, uint32(4), res.Code)
+	require.Zero(t, node.count("tx"))
+
+	node = &mockNode{broadcastSync: acceptTx, tx: txAfter(0, abci.ResponseDeliverTx{Code: 1, Log: "failed: 100%d"})}
+	res, err = waitContext(node).BroadcastTx(tmtypes.Tx("tx"))
+	require.EqualError(t, err, "failed: 100%d")
+	require.Equal(t, uint32(1), res.Code)
+	require.Equal(t, int64(5), res.Height)
+
+	// a node without a tx index can't be polled
+	node = &mockNode{
+		broadcastSync: acceptTx,
+		tx: func(hash []byte) (*ctypes.ResultTx, error) {
+			return nil, rpcError("Transaction indexing is disabled")
+		},
+	}
+	_, err = waitContext(node).BroadcastTx(tmtypes.Tx("tx"))
+	require.NotNil(t, err)
+	require.Equal(t, 1, node.count("tx"))
+}
+
+func TestWaitForTxTimeout(t *testing.T) {
+	hash := tmtypes.Tx("tx").Hash()
+
+	// without a block limit the error has the height at the deadline
+	node := &mockNode{status: statusAt(7, 1), tx: txAfter(1000, abci.ResponseDeliverTx{})}
+	ctx := waitContext(node)
+	ctx.BroadcastTimeout = 20 * time.Millisecond
+	res, err := ctx.WaitForTx(hash)
+	timeoutErr, ok := err.(*TxTimeoutError)
+	require.True(t, ok, err)
+	require.Equal(t, int64(7), timeoutErr.Height)
+	require.Equal(t, res.TxHash, timeoutErr.TxHash)
+	require.Nil(t, timeoutErr.Err)
+
+	// the block limit is counted from the height at the start
+	node = &mockNode{status: statusAt(7, 1), tx: txAfter(1000, abci.ResponseDeliverTx{})}
+	ctx = waitContext(node)
+	ctx.BroadcastMaxBlocks = 3
+	_, err = ctx.WaitForTx(hash)
+	timeoutErr, ok = err.(*TxTimeoutError)
+	require.True(t, ok, err)
+	require.Equal(t, int64(10), timeoutErr.Height)
+	require.Equal(t, 3, node.count("tx"))
+
+	// other errors are kept, the node may be down for a while
+	node = &mockNode{
+		status: statusAt(7, 0),
+		tx: func(hash []byte) (*ctypes.ResultTx, error) {
+			return nil, errors.New("connection refused")
+		},
+	}
+	ctx = waitContext(node)
+	ctx.BroadcastTimeout = 10 * time.Millisecond
+	_, err = ctx.WaitForTx(hash)
+	timeoutErr, ok = err.(*TxTimeoutError)
+	require.True(t, ok, err)
+	require.EqualError(t, timeoutErr.Err, "connection refused")
+}
+
+func TestWaitForTxCancel(t *testing.T) {
+	node := &mockNode{tx: txAfter(1000, abci.ResponseDeliverTx{})}
+	ctx := waitContext(node)
+	ctx.BroadcastTimeout = time.Minute
+
+	goCtx, cancel := gocontext.WithTimeout(gocontext.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	res, err := ctx.WaitForTxContext(goCtx, tmtypes.Tx("tx").Hash())
+	require.Equal(t, gocontext.DeadlineExceeded, err)
+	require.NotEmpty(t, res.TxHash)
+	require.True(t, time.Since(start) < time.Second)
+}
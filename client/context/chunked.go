@@ -0,0 +1,117 @@
+package context
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	cmn "github.com/tendermint/tendermint/libs/common"
+)
+
+var (
+	errReadOnlyStore  = errors.New("store queried through a node is read-only")
+	errIterationQuery = errors.New("store queried through a node can't be iterated")
+	errCacheWrapQuery = errors.New("store queried through a node can't be cache wrapped")
+)
+
+var _ sdk.KVStore = (*queryStore)(nil)
+
+// QueryChunkedStore reads a value written with storewrapper.KVStore.SetW
+// without a namespace. All chunks are fetched at one height, which is
+// returned, and their proofs are verified unless the node is trusted.
+func (ctx Context) QueryChunkedStore(key cmn.HexBytes, storeName string) ([]byte, int64, error) {
+	stw, height, err := ctx.ChunkedStore(storeName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res, err := stw.GetW(key)
+	return res, height, err
+}
+
+// ChunkedStore returns a read-only storewrapper.KVStore backed by store
+// queries at the context height or, if it isn't set, the latest height whose
+// proofs can be verified. It can be configured like the wrapper the value was
+// written with, e.g. with WithNamespace, and read with GetW, GetRangeW or
+// GetVersionW. Iteration isn't supported.
+func (ctx *Context) ChunkedStore(storeName string) (*storewrapper.KVStore, int64, error) {
+	height := ctx.Height
+	if height == 0 {
+		latest, err := ctx.latestHeight()
+		if err != nil {
+			return nil, 0, err
+		}
+		height = latest
+
+		// the AppHash of height H is in the header of H+1, which doesn't
+		// exist yet for the latest height
+		if !ctx.TrustNode && height > 1 {
+			height--
+		}
+	}
+
+	store := &queryStore{
+		ctx:       ctx,
+		storeName: storeName,
+		height:    height,
+	}
+
+	return storewrapper.NewKVStore(store, 0), height, nil
+}
+
+// queryStore is a read-only KVStore fetching keys with store queries at a
+// fixed height. Query errors are raised as panics, which the wrapper turns
+// into errors; so are writes, iteration and cache wrapping.
+type queryStore struct {
+	ctx       *Context
+	storeName string
+	height    int64
+}
+
+func (s *queryStore) Get(key []byte) []byte {
+	path := fmt.Sprintf("/store/%s/key", s.storeName)
+
+	res, _, err := s.ctx.queryAt(path, key, s.height)
+	if err != nil {
+		panic(err)
+	}
+	if len(res) == 0 {
+		return nil
+	}
+
+	return res
+}
+
+func (s *queryStore) Has(key []byte) bool {
+	return s.Get(key) != nil
+}
+
+func (s *queryStore) Set(key, value []byte) {
+	panic(errReadOnlyStore)
+}
+
+func (s *queryStore) Delete(key []byte) {
+	panic(errReadOnlyStore)
+}
+
+func (s *queryStore) Iterator(start, end []byte) sdk.Iterator {
+	panic(errIterationQuery)
+}
+
+func (s *queryStore) ReverseIterator(start, end []byte) sdk.Iterator {
+	panic(errIterationQuery)
+}
+
+func (s *queryStore) GetStoreType() sdk.StoreType {
+	return sdk.StoreTypeIAVL
+}
+
+func (s *queryStore) CacheWrap() sdk.CacheWrap {
+	panic(errCacheWrapQuery)
+}
+
+func (s *queryStore) CacheWrapWithTrace(w io.Writer, tc sdk.TraceContext) sdk.CacheWrap {
+	panic(errCacheWrapQuery)
+}
@@ -0,0 +1,145 @@
+package context
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/stretchr/testify/require"
+	abci "github.com/tendermint/tendermint/abci/types"
+	cmn "github.com/tendermint/tendermint/libs/common"
+	rpcclient "github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+)
+
+// mapStore is the state of a store at one height
+type mapStore struct {
+	sdk.KVStore
+	values map[string][]byte
+}
+
+func (s *mapStore) Get(key []byte) []byte { return s.values[string(key)] }
+func (s *mapStore) Has(key []byte) bool   { return s.values[string(key)] != nil }
+func (s *mapStore) Set(key, value []byte) { s.values[string(key)] = value }
+func (s *mapStore) Delete(key []byte)     { delete(s.values, string(key)) }
+
+// chunkedNode returns a node answering store queries from the states of a
+// store by height and the heights queried
+func chunkedNode(states map[int64]*mapStore, latest int64) (*mockNode, func() []int64) {
+	var (
+		mtx     sync.Mutex
+		heights []int64
+	)
+
+	node := &mockNode{
+		status: statusAt(latest, 1),
+		abciQuery: func(path string, data cmn.HexBytes, opts rpcclient.ABCIQueryOptions) (*ctypes.ResultABCIQuery, error) {
+			mtx.Lock()
+			heights = append(heights, opts.Height)
+			mtx.Unlock()
+
+			state, ok := states[opts.Height]
+			if !ok {
+				return nil, rpcError(fmt.Sprintf("no state at height %d", opts.Height))
+			}
+			return &ctypes.ResultABCIQuery{Response: abci.ResponseQuery{
+				Key:    data,
+				Value:  state.Get(data),
+				Height: opts.Height,
+			}}, nil
+		},
+	}
+
+	return node, func() []int64 {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return append([]int64{}, heights...)
+	}
+}
+
+// stateWith returns the state of a store with a value written by storewrapper
+func stateWith(t *testing.T, key, value []byte) *mapStore {
+	state := &mapStore{values: make(map[string][]byte)}
+	_, err := storewrapper.NewKVStore(state, storewrapper.MinChunkSize).SetW(key, value)
+	require.Nil(t, err)
+	return state
+}
+
+func TestChunkedStoreHeight(t *testing.T) {
+	node, _ := chunkedNode(nil, 10)
+
+	// a proof of height H is checked against the header of H+1
+	ctx := &Context{Client: node}
+	_, height, err := ctx.ChunkedStore("main")
+	require.Nil(t, err)
+	require.Equal(t, int64(9), height)
+
+	ctx = &Context{Client: node, TrustNode: true}
+	_, height, err = ctx.ChunkedStore("main")
+	require.Nil(t, err)
+	require.Equal(t, int64(11), height)
+
+	ctx = &Context{Client: node, Height: 5}
+	_, height, err = ctx.ChunkedStore("main")
+	require.Nil(t, err)
+	require.Equal(t, int64(5), height)
+	require.Equal(t, 2, node.count("status"))
+}
+
+func TestQueryChunkedStore(t *testing.T) {
+	key := []byte("key")
+	old := bytes.Repeat([]byte("old"), 1000)
+	value := bytes.Repeat([]byte("new"), 1000)
+
+	// the value changes while it is read, but all chunks are read at one
+	// height
+	node, heights := chunkedNode(map[int64]*mapStore{
+		10: stateWith(t, key, old),
+		11: stateWith(t, key, value),
+	}, 10)
+	ctx := &Context{Client: node, TrustNode: true}
+
+	res, height, err := ctx.QueryChunkedStore(key, "main")
+	require.Nil(t, err)
+	require.Equal(t, int64(10), height)
+	require.Equal(t, old, res)
+
+	queried := heights()
+	require.True(t, len(queried) > 2)
+	for _, h := range queried {
+		require.Equal(t, int64(10), h)
+	}
+}
+
+func TestQueryChunkedStoreErrors(t *testing.T) {
+	key := []byte("key")
+	node, _ := chunkedNode(map[int64]*mapStore{}, 10)
+	ctx := &Context{Client: node, TrustNode: true}
+
+	// errors of queries are returned instead of panicking
+	_, _, err := ctx.QueryChunkedStore(key, "main")
+	require.Equal(t, rpcError("no state at height 10"), err)
+
+	node.abciQuery = func(path string, data cmn.HexBytes, opts rpcclient.ABCIQueryOptions) (*ctypes.ResultABCIQuery, error) {
+		return &ctypes.ResultABCIQuery{Response: abci.ResponseQuery{Code: 1, Log: "unknown store"}}, nil
+	}
+	_, _, err = ctx.QueryChunkedStore(key, "main")
+	require.EqualError(t, err, "unknown store")
+
+	// missing values are empty
+	node, _ = chunkedNode(map[int64]*mapStore{10: stateWith(t, []byte("other"), []byte("value"))}, 10)
+	ctx = &Context{Client: node, TrustNode: true}
+	res, _, err := ctx.QueryChunkedStore(key, "main")
+	require.Nil(t, err)
+	require.Empty(t, res)
+
+	// the store is read-only
+	ctx.Height = 10
+	stw, _, err := ctx.ChunkedStore("main")
+	require.Nil(t, err)
+	_, err = stw.SetW(key, []byte("value"))
+	require.Equal(t, errReadOnlyStore, err)
+}
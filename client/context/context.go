@@ -1,6 +1,7 @@
 package context
 
 import (
+	gocontext "context"
 	"fmt"
 	"io"
 	"path/filepath"
@@ -15,8 +16,10 @@ import (
 	"github.com/tendermint/tendermint/crypto"
 	"github.com/tendermint/tendermint/libs/log"
 	tmlite "github.com/tendermint/tendermint/lite"
+	lclient "github.com/tendermint/tendermint/lite/client"
 	tmliteProxy "github.com/tendermint/tendermint/lite/proxy"
 	rpcclient "github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
 )
 
 // Context implements a typical CLI context created in SDK modules for
@@ -47,6 +50,17 @@ type Context struct {
 	Passphrase    string
 	PrivKey       crypto.PrivKey
 	mtx           sync.RWMutex
+
+	// BroadcastWait mode settings; zero values mean the defaults and no
+	// block limit
+	BroadcastTimeout      time.Duration
+	BroadcastMaxBlocks    int64
+	BroadcastPollInterval time.Duration
+
+	// Nodes is used instead of Client if it is set
+	Nodes *NodePool
+
+	readiness *readiness
 }
 
 // NewContext returns a new initialized Context
@@ -60,68 +74,46 @@ func NewContext(chainID string, nodeURI string, home string) (*Context, error) {
 		rpc = rpcclient.NewHTTP(nodeURI, "/websocket")
 	}
 
-	verifier, err := createVerifier(chainID, home, nodeURI)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Context{
+	ctx := &Context{
 		Client:        rpc,
 		NodeURI:       nodeURI,
 		AccountStore:  AccountStoreKey,
-		verifier:      verifier,
 		Home:          home,
 		BroadcastMode: BroadcastSync,
-	}, nil
-}
-
-func NewContextWithDelay(chainID string, nodeURI string, home string) (*Context, error) {
-	var (
-		rpc rpcclient.Client
-		ctx *Context
-	)
-
-	//t := strconv.FormatInt(time.Now().UnixNano(), 10)
-	//home += t
+	}
 
-	if nodeURI != "" {
-		rpc = rpcclient.NewHTTP(nodeURI, "/websocket")
-	} else {
-		return nil, fmt.Errorf("no nodeURI specified")
+	ctx.verifier, err = createVerifier(chainID, home, nodeURI, verifierSource{ctx})
+	if err != nil {
+		return nil, err
 	}
 
-	var verifier tmlite.Verifier
-	ctx = &Context{
-		Client:        rpc,
-		NodeURI:       nodeURI,
-		AccountStore:  AccountStoreKey,
-		verifier:      verifier,
-		Home:          home,
-		BroadcastMode: BroadcastSync,
+	return ctx, nil
+}
+
+// NewContextWithDelay returns a context that gets its verifier once the node
+// is up. It gives up after DefaultDelayTimeout; Ready and ReadyErr report the
+// result.
+//
+// Deprecated: use NewContextWhenReady, which can be cancelled and reports
+// failed attempts.
+func NewContextWithDelay(chainID string, nodeURI string, home string) (*Context, error) {
+	goCtx, cancel := gocontext.WithTimeout(gocontext.Background(), DefaultDelayTimeout)
+	ctx, _, err := NewContextWhenReady(goCtx, chainID, nodeURI, home, ReadyOptions{})
+	if err != nil {
+		cancel()
+		return nil, err
 	}
 	go func() {
-		for {
-			node := rpcclient.NewHTTP(nodeURI, "/websocket")
-			st, err := node.Status()
-			if err != nil {
-				fmt.Printf("node is not running, status: %#+v", st)
-				time.Sleep(time.Second * 4)
-			} else {
-				break
-			}
-		}
-
-		verifier, err := createVerifier(chainID, home, nodeURI)
-		if err != nil {
-			fmt.Printf("could not create verifier, error: %v", err)
-		}
-		ctx.WithVerifier(verifier)
+		<-ctx.Ready()
+		cancel()
 	}()
 
 	return ctx, nil
 }
 
-func createVerifier(chainID string, home string, nodeURI string) (tmlite.Verifier, error) {
+// createVerifier returns a verifier getting headers and validators from
+// source; nodeURI is required, since the context falls back to it
+func createVerifier(chainID string, home string, nodeURI string, source lclient.SignStatusClient) (tmlite.Verifier, error) {
 	if chainID == "" {
 		return nil, errors.New("Invalid chainID")
 	}
@@ -132,11 +124,10 @@ func createVerifier(chainID string, home string, nodeURI string) (tmlite.Verifie
 		return nil, errors.New("Invalid nodeURI")
 	}
 
-	node := rpcclient.NewHTTP(nodeURI, "/websocket")
 	cacheSize := 10 // TODO: determine appropriate cache size
 	verifier, err := tmliteProxy.NewVerifier(
 		chainID, filepath.Join(home, ".gaialite"),
-		node, log.NewNopLogger(), cacheSize,
+		source, log.NewNopLogger(), cacheSize,
 	)
 
 	if err != nil {
@@ -202,6 +193,13 @@ func (ctx *Context) WithClient(client rpcclient.Client) *Context {
 	return ctx
 }
 
+// WithNodes returns a copy of the context sending queries and transactions
+// to a pool of nodes instead of Client.
+func (ctx *Context) WithNodes(nodes *NodePool) *Context {
+	ctx.Nodes = nodes
+	return ctx
+}
+
 // WithUseLedger returns a copy of the context with an updated UseLedger flag.
 func (ctx *Context) WithUseLedger(useLedger bool) *Context {
 	ctx.UseLedger = useLedger
@@ -266,6 +264,14 @@ func (ctx *Context) WithBroadcastMode(mode string) *Context {
 	return ctx
 }
 
+// WithBroadcastWait returns a copy of the context waiting for up to timeout
+// and maxBlocks blocks for transactions broadcast in BroadcastWait mode.
+func (ctx *Context) WithBroadcastWait(timeout time.Duration, maxBlocks int64) *Context {
+	ctx.BroadcastTimeout = timeout
+	ctx.BroadcastMaxBlocks = maxBlocks
+	return ctx
+}
+
 // PrintOutput prints output while respecting output and indent flags
 // NOTE: pass in marshalled structs that have been unmarshaled
 // because this function will panic on marshaling errors
@@ -320,3 +326,52 @@ func GetFromFields(from string, home string) (sdk.AccAddress, string, error) {
 
 	return info.GetAddress(), info.GetName(), nil
 }
+
+// verifierSource is the source of the verifier of a context: its node pool,
+// once it has one, so a node being down doesn't fail verification, otherwise
+// its client. Requests the verifier doesn't make go to the client.
+type verifierSource struct {
+	ctx *Context
+}
+
+var _ lclient.SignStatusClient = verifierSource{}
+
+func (s verifierSource) Status() (res *ctypes.ResultStatus, err error) {
+	err = s.ctx.withNode(verifyRequest, func(node rpcclient.Client) (err error) {
+		res, err = node.Status()
+		return err
+	})
+	return res, err
+}
+
+func (s verifierSource) Commit(height *int64) (res *ctypes.ResultCommit, err error) {
+	err = s.ctx.withNode(verifyRequest, func(node rpcclient.Client) (err error) {
+		res, err = node.Commit(height)
+		return err
+	})
+	return res, err
+}
+
+func (s verifierSource) Validators(height *int64) (res *ctypes.ResultValidators, err error) {
+	err = s.ctx.withNode(verifyRequest, func(node rpcclient.Client) (err error) {
+		res, err = node.Validators(height)
+		return err
+	})
+	return res, err
+}
+
+func (s verifierSource) Block(height *int64) (*ctypes.ResultBlock, error) {
+	return s.ctx.Client.Block(height)
+}
+
+func (s verifierSource) BlockResults(height *int64) (*ctypes.ResultBlockResults, error) {
+	return s.ctx.Client.BlockResults(height)
+}
+
+func (s verifierSource) Tx(hash []byte, prove bool) (*ctypes.ResultTx, error) {
+	return s.ctx.Client.Tx(hash, prove)
+}
+
+func (s verifierSource) TxSearch(query string, prove bool, page, perPage int) (*ctypes.ResultTxSearch, error) {
+	return s.ctx.Client.TxSearch(query, prove, page, perPage)
+}
@@ -9,6 +9,7 @@ import (
 
 var (
 	ErrInvalidSigner = errors.New("Invalid signer")
+	ErrNotReady      = errors.New("context is waiting for its node")
 )
 
 // ErrInvalidAccount returns a standardized error reflecting that a given
@@ -25,3 +26,19 @@ func ErrVerifyCommit(height int64) error {
 	return fmt.Errorf(`The height of base truststore in gaia-lite is higher than height %d. 
 Can't verify blockchain proof at this height. Please set --trust-node to true and try again`, height)
 }
+
+// TxTimeoutError is returned when a broadcast transaction wasn't committed in
+// time. It can still be included in a later block, so it can be tracked by
+// TxHash, e.g. with WaitForTx.
+type TxTimeoutError struct {
+	TxHash string
+	Height int64
+	Err    error
+}
+
+func (e *TxTimeoutError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("tx %s was not committed by height %d: %v", e.TxHash, e.Height, e.Err)
+	}
+	return fmt.Sprintf("tx %s was not committed by height %d", e.TxHash, e.Height)
+}
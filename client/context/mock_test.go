@@ -0,0 +1,110 @@
+package context
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+	cmn "github.com/tendermint/tendermint/libs/common"
+	rpcclient "github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	rpctypes "github.com/tendermint/tendermint/rpc/lib/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// mockNode is an RPC client answering requests with its funcs and counting
+// them; other requests panic
+type mockNode struct {
+	rpcclient.Client
+
+	status          func() (*ctypes.ResultStatus, error)
+	broadcastSync   func(tx tmtypes.Tx) (*ctypes.ResultBroadcastTx, error)
+	broadcastCommit func(tx tmtypes.Tx) (*ctypes.ResultBroadcastTxCommit, error)
+	tx              func(hash []byte) (*ctypes.ResultTx, error)
+	commit          func(height *int64) (*ctypes.ResultCommit, error)
+	abciQuery       func(path string, data cmn.HexBytes, opts rpcclient.ABCIQueryOptions) (*ctypes.ResultABCIQuery, error)
+
+	mtx   sync.Mutex
+	calls map[string]int
+}
+
+func (n *mockNode) call(name string) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if n.calls == nil {
+		n.calls = make(map[string]int)
+	}
+	n.calls[name]++
+}
+
+func (n *mockNode) count(name string) int {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	return n.calls[name]
+}
+
+func (n *mockNode) Status() (*ctypes.ResultStatus, error) {
+	n.call("status")
+	return n.status()
+}
+
+func (n *mockNode) BroadcastTxSync(tx tmtypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+	n.call("broadcast_tx_sync")
+	return n.broadcastSync(tx)
+}
+
+func (n *mockNode) BroadcastTxCommit(tx tmtypes.Tx) (*ctypes.ResultBroadcastTxCommit, error) {
+	n.call("broadcast_tx_commit")
+	return n.broadcastCommit(tx)
+}
+
+func (n *mockNode) Tx(hash []byte, prove bool) (*ctypes.ResultTx, error) {
+	n.call("tx")
+	return n.tx(hash)
+}
+
+func (n *mockNode) ABCIQueryWithOptions(path string, data cmn.HexBytes, opts rpcclient.ABCIQueryOptions) (*ctypes.ResultABCIQuery, error) {
+	n.call("abci_query")
+	return n.abciQuery(path, data, opts)
+}
+
+func (n *mockNode) Commit(height *int64) (*ctypes.ResultCommit, error) {
+	n.call("commit")
+	return n.commit(height)
+}
+
+// statusAt returns a status func reporting height, which grows by step on
+// every call
+func statusAt(height, step int64) func() (*ctypes.ResultStatus, error) {
+	var mtx sync.Mutex
+	return func() (*ctypes.ResultStatus, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		res := &ctypes.ResultStatus{}
+		res.SyncInfo.LatestBlockHeight = height
+		height += step
+		return res, nil
+	}
+}
+
+// rpcError returns an error like the one of the HTTP client for an error
+// of the node
+func rpcError(data string) error {
+	return &rpctypes.RPCError{Code: -32603, Message: "Internal error", Data: data}
+}
+
+// connError returns an error like the one of the HTTP client when the
+// connection fails in the given operation, e.g. "dial" or "read"
+func connError(op string) error {
+	err := &url.Error{
+		Op:  "Post",
+		URL: "http://node:26657",
+		Err: &net.OpError{Op: op, Net: "tcp", Err: syscall.ECONNREFUSED},
+	}
+	return errors.Wrap(err, "request failed")
+}
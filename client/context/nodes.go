@@ -0,0 +1,286 @@
+package context
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	rpcclient "github.com/tendermint/tendermint/rpc/client"
+)
+
+// NodeStatus describes an endpoint of a NodePool as of its last health check
+// or request
+type NodeStatus struct {
+	URI        string
+	Healthy    bool
+	CatchingUp bool
+	Height     int64
+	Latency    time.Duration
+	LastCheck  time.Time
+	LastError  error
+}
+
+// NodePool is a set of nodes of one chain used by a Context instead of a
+// single client. Queries failing with a connection error are retried with
+// the next healthy node and mark the failed one unhealthy until a health
+// check or another request succeeds. Transactions are only retried if the
+// node couldn't be dialed, since otherwise they may have been received.
+// Queries go to the healthiest node or, with RoundRobin, to healthy nodes in
+// turn; transactions and queries of the verifier always go to the healthiest
+// node. It is safe for concurrent use.
+type NodePool struct {
+	RoundRobin bool
+
+	mtx   sync.RWMutex
+	nodes []*poolNode
+	next  int
+}
+
+type poolNode struct {
+	client rpcclient.Client
+	status NodeStatus
+}
+
+// NewNodePool returns a pool of nodes with the given RPC endpoints. Nodes are
+// considered healthy until they fail.
+func NewNodePool(nodeURIs ...string) *NodePool {
+	p := &NodePool{}
+	for _, uri := range nodeURIs {
+		p.AddNode(uri, rpcclient.NewHTTP(uri, "/websocket"))
+	}
+
+	return p
+}
+
+// AddNode adds a node with the given client to the pool
+func (p *NodePool) AddNode(uri string, client rpcclient.Client) *NodePool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.nodes = append(p.nodes, &poolNode{
+		client: client,
+		status: NodeStatus{URI: uri, Healthy: true},
+	})
+
+	return p
+}
+
+// Status returns the status of every node in the order they were added
+func (p *NodePool) Status() []NodeStatus {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	res := make([]NodeStatus, len(p.nodes))
+	for i, n := range p.nodes {
+		res[i] = n.status
+	}
+
+	return res
+}
+
+// CheckHealth requests the status of every node and updates their health.
+// Nodes catching up are healthy, but are used only if no other node is.
+func (p *NodePool) CheckHealth() {
+	p.mtx.RLock()
+	nodes := append([]*poolNode{}, p.nodes...)
+	p.mtx.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n *poolNode) {
+			defer wg.Done()
+
+			start := time.Now()
+			st, err := n.client.Status()
+
+			p.mtx.Lock()
+			defer p.mtx.Unlock()
+			n.status.LastCheck = time.Now()
+			n.status.LastError = err
+			n.status.Healthy = err == nil
+			if err == nil {
+				n.status.Latency = time.Since(start)
+				n.status.Height = st.SyncInfo.LatestBlockHeight
+				n.status.CatchingUp = st.SyncInfo.CatchingUp
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+// StartHealthChecks runs CheckHealth every interval until the returned func
+// is called
+func (p *NodePool) StartHealthChecks(interval time.Duration) (stop func()) {
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			p.CheckHealth()
+			select {
+			case <-ticker.C:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(quit) }) }
+}
+
+// Healthiest returns the client of the healthiest node: a healthy one that
+// isn't catching up with the highest height and the lowest latency
+func (p *NodePool) Healthiest() (rpcclient.Client, error) {
+	nodes := p.candidates(false)
+	if len(nodes) == 0 {
+		return nil, errors.New("no nodes in pool")
+	}
+
+	return nodes[0].client, nil
+}
+
+// Query returns the client of the node for the next query
+func (p *NodePool) Query() (rpcclient.Client, error) {
+	nodes := p.candidates(p.RoundRobin)
+	if len(nodes) == 0 {
+		return nil, errors.New("no nodes in pool")
+	}
+
+	return nodes[0].client, nil
+}
+
+// nodeRequest is the kind of a request sent with NodePool.do
+type nodeRequest int
+
+const (
+	queryRequest nodeRequest = iota
+	// verifyRequest is a query of the verifier, which doesn't take a turn
+	// of round-robin
+	verifyRequest
+	broadcastRequest
+)
+
+// do calls f with the clients of healthy nodes in order of preference until
+// it doesn't fail with a connection error or, for a broadcast, until the
+// node could be dialed. Unhealthy nodes are tried last.
+func (p *NodePool) do(req nodeRequest, f func(node rpcclient.Client) error) error {
+	nodes := p.candidates(p.RoundRobin && req == queryRequest)
+	if len(nodes) == 0 {
+		return errors.New("no nodes in pool")
+	}
+
+	retry := isConnectionError
+	if req == broadcastRequest {
+		retry = isDialError
+	}
+
+	var err error
+	for _, n := range nodes {
+		err = f(n.client)
+		if !isConnectionError(err) {
+			p.report(n, nil)
+			return err
+		}
+		p.report(n, err)
+		if !retry(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// candidates returns the nodes ordered by preference: healthy nodes that
+// aren't catching up, then ones catching up, then unhealthy ones. With
+// roundRobin the first group starts with the next node in turn, otherwise it
+// is ordered by height and latency.
+func (p *NodePool) candidates(roundRobin bool) []*poolNode {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if len(p.nodes) == 0 {
+		return nil
+	}
+
+	start := 0
+	if roundRobin {
+		start = p.next % len(p.nodes)
+		p.next++
+	}
+
+	res := make([]*poolNode, 0, len(p.nodes))
+	for i := range p.nodes {
+		res = append(res, p.nodes[(start+i)%len(p.nodes)])
+	}
+
+	rank := func(n *poolNode) int {
+		switch {
+		case !n.status.Healthy:
+			return 2
+		case n.status.CatchingUp:
+			return 1
+		}
+		return 0
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if rank(a) != rank(b) {
+			return rank(a) < rank(b)
+		}
+		if roundRobin {
+			return false
+		}
+		if a.status.Height != b.status.Height {
+			return a.status.Height > b.status.Height
+		}
+		return a.status.Latency < b.status.Latency
+	})
+
+	return res
+}
+
+// report records the result of a request to a node; a connection error makes
+// it unhealthy until a health check or another request succeeds
+func (p *NodePool) report(n *poolNode, err error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	n.status.LastError = err
+	n.status.Healthy = err == nil
+}
+
+// isConnectionError reports whether err means the node couldn't be reached
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch errors.Cause(err).(type) {
+	case *url.Error, net.Error:
+		return true
+	}
+
+	return false
+}
+
+// isDialError reports whether err means the connection to the node couldn't
+// be established, so the request wasn't sent
+func isDialError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	cause := errors.Cause(err)
+	if urlErr, ok := cause.(*url.Error); ok {
+		cause = urlErr.Err
+	}
+	opErr, ok := cause.(*net.OpError)
+
+	return ok && opErr.Op == "dial"
+}
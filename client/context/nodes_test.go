@@ -0,0 +1,182 @@
+package context
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	cmn "github.com/tendermint/tendermint/libs/common"
+	rpcclient "github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+func failingStatus(err error) func() (*ctypes.ResultStatus, error) {
+	return func() (*ctypes.ResultStatus, error) {
+		return nil, err
+	}
+}
+
+func failingBroadcast(err error) func(tx tmtypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+	return func(tx tmtypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+		return nil, err
+	}
+}
+
+func TestNodePoolQueryFailover(t *testing.T) {
+	down := &mockNode{status: failingStatus(connError("read"))}
+	up := &mockNode{status: statusAt(10, 0)}
+	ctx := &Context{Nodes: NewNodePool().AddNode("down", down).AddNode("up", up)}
+
+	height, err := ctx.latestHeight()
+	require.Nil(t, err)
+	require.Equal(t, int64(10), height)
+	require.Equal(t, 1, down.count("status"))
+
+	statuses := ctx.Nodes.Status()
+	require.False(t, statuses[0].Healthy)
+	require.NotNil(t, statuses[0].LastError)
+	require.True(t, statuses[1].Healthy)
+
+	// the unhealthy node is tried last
+	_, err = ctx.latestHeight()
+	require.Nil(t, err)
+	require.Equal(t, 1, down.count("status"))
+}
+
+func TestNodePoolBroadcastFailover(t *testing.T) {
+	tx := tmtypes.Tx("tx")
+
+	// a node that can't be dialed didn't get the transaction
+	down := &mockNode{broadcastSync: failingBroadcast(connError("dial"))}
+	up := &mockNode{broadcastSync: acceptTx}
+	ctx := &Context{Nodes: NewNodePool().AddNode("down", down).AddNode("up", up)}
+
+	res, err := ctx.BroadcastTxSync(tx)
+	require.Nil(t, err)
+	require.Equal(t, cmn.HexBytes(tx.Hash()).String(), res.TxHash)
+	require.Equal(t, 1, down.count("broadcast_tx_sync"))
+	require.Equal(t, 1, up.count("broadcast_tx_sync"))
+
+	// a node failing after the transaction was sent may have got it, so it
+	// isn't sent again and the hash is returned to look it up
+	broken := &mockNode{broadcastSync: failingBroadcast(connError("read"))}
+	up = &mockNode{broadcastSync: acceptTx}
+	ctx = &Context{Nodes: NewNodePool().AddNode("broken", broken).AddNode("up", up)}
+
+	res, err = ctx.BroadcastTxSync(tx)
+	require.NotNil(t, err)
+	require.Equal(t, cmn.HexBytes(tx.Hash()).String(), res.TxHash)
+	require.Equal(t, 0, up.count("broadcast_tx_sync"))
+	require.False(t, ctx.Nodes.Status()[0].Healthy)
+
+	// errors of the node don't make it unhealthy
+	rejecting := &mockNode{broadcastSync: failingBroadcast(rpcError("mempool is full"))}
+	ctx = &Context{Nodes: NewNodePool().AddNode("rejecting", rejecting).AddNode("up", up)}
+
+	res, err = ctx.BroadcastTxSync(tx)
+	require.NotNil(t, err)
+	require.Empty(t, res.TxHash)
+	require.Equal(t, 0, up.count("broadcast_tx_sync"))
+	require.True(t, ctx.Nodes.Status()[0].Healthy)
+}
+
+func TestNodePoolRoundRobin(t *testing.T) {
+	nodes := []*mockNode{{}, {}, {}}
+	p := NewNodePool()
+	p.RoundRobin = true
+	for i, n := range nodes {
+		p.AddNode(fmt.Sprintf("node%d", i), n)
+	}
+
+	var used []rpcclient.Client
+	record := func(node rpcclient.Client) error {
+		used = append(used, node)
+		return nil
+	}
+
+	// queries of the verifier and broadcasts don't take a turn
+	require.Nil(t, p.do(queryRequest, record))
+	require.Nil(t, p.do(verifyRequest, record))
+	require.Nil(t, p.do(broadcastRequest, record))
+	require.Nil(t, p.do(queryRequest, record))
+	require.Nil(t, p.do(queryRequest, record))
+	require.Equal(t, []rpcclient.Client{nodes[0], nodes[0], nodes[0], nodes[1], nodes[2]}, used)
+}
+
+func TestNodePoolCheckHealth(t *testing.T) {
+	catchingUp := func() (*ctypes.ResultStatus, error) {
+		res := &ctypes.ResultStatus{}
+		res.SyncInfo.LatestBlockHeight = 20
+		res.SyncInfo.CatchingUp = true
+		return res, nil
+	}
+	nodes := []*mockNode{
+		{status: failingStatus(connError("dial"))},
+		{status: catchingUp},
+		{status: statusAt(10, 0)},
+		{status: statusAt(12, 0)},
+	}
+	p := NewNodePool()
+	for i, n := range nodes {
+		p.AddNode(fmt.Sprintf("node%d", i), n)
+	}
+
+	p.CheckHealth()
+
+	statuses := p.Status()
+	require.False(t, statuses[0].Healthy)
+	require.False(t, statuses[0].LastCheck.IsZero())
+	require.True(t, statuses[1].Healthy)
+	require.True(t, statuses[1].CatchingUp)
+	require.Equal(t, int64(10), statuses[2].Height)
+	for _, n := range nodes {
+		require.Equal(t, 1, n.count("status"))
+	}
+
+	// the highest node that isn't catching up is the healthiest
+	node, err := p.Healthiest()
+	require.Nil(t, err)
+	require.Equal(t, nodes[3], node)
+
+	// nodes catching up are used before unhealthy ones
+	nodes[2].status = failingStatus(errors.New("stopped"))
+	nodes[3].status = failingStatus(errors.New("stopped"))
+	p.CheckHealth()
+
+	node, err = p.Healthiest()
+	require.Nil(t, err)
+	require.Equal(t, nodes[1], node)
+}
+
+func TestVerifierSource(t *testing.T) {
+	commitAt := func(height *int64) (*ctypes.ResultCommit, error) {
+		res := &ctypes.ResultCommit{}
+		res.Header = &tmtypes.Header{Height: *height}
+		return res, nil
+	}
+	single := &mockNode{commit: commitAt}
+	ctx := &Context{Client: single}
+	source := verifierSource{ctx}
+
+	height := int64(5)
+	res, err := source.Commit(&height)
+	require.Nil(t, err)
+	require.Equal(t, int64(5), res.Height)
+	require.Equal(t, 1, single.count("commit"))
+
+	// with a pool the verifier doesn't depend on the node of the context
+	down := &mockNode{commit: func(height *int64) (*ctypes.ResultCommit, error) {
+		return nil, connError("dial")
+	}}
+	up := &mockNode{commit: commitAt}
+	ctx.WithNodes(NewNodePool().AddNode("down", down).AddNode("up", up))
+
+	res, err = source.Commit(&height)
+	require.Nil(t, err)
+	require.Equal(t, int64(5), res.Height)
+	require.Equal(t, 1, down.count("commit"))
+	require.Equal(t, 1, up.count("commit"))
+	require.Equal(t, 1, single.count("commit"))
+}
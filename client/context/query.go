@@ -13,12 +13,17 @@ import (
 	tmliteErr "github.com/tendermint/tendermint/lite/errors"
 	tmliteProxy "github.com/tendermint/tendermint/lite/proxy"
 	rpcclient "github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
 	tmtypes "github.com/tendermint/tendermint/types"
 )
 
-// GetNode returns an RPC client. If the context's client is not defined, an
-// error is returned.
+// GetNode returns an RPC client, the one for the next query if the context
+// has a node pool. If the context's client is not defined, an error is
+// returned.
 func (ctx Context) GetNode() (rpcclient.Client, error) {
+	if ctx.Nodes != nil {
+		return ctx.Nodes.Query()
+	}
 	if ctx.Client == nil {
 		return nil, errors.New("no RPC client defined")
 	}
@@ -26,6 +31,35 @@ func (ctx Context) GetNode() (rpcclient.Client, error) {
 	return ctx.Client, nil
 }
 
+// withNode calls f with the node for a request of the given kind. With a
+// node pool f is retried with other nodes if it fails with a connection
+// error, a broadcast only if the node couldn't be dialed.
+func (ctx *Context) withNode(req nodeRequest, f func(node rpcclient.Client) error) error {
+	if ctx.Nodes != nil {
+		return ctx.Nodes.do(req, f)
+	}
+
+	node, err := ctx.GetNode()
+	if err != nil {
+		return err
+	}
+
+	return f(node)
+}
+
+// latestHeight returns the latest block height of the node
+func (ctx *Context) latestHeight() (height int64, err error) {
+	err = ctx.withNode(queryRequest, func(node rpcclient.Client) error {
+		status, err := node.Status()
+		if err == nil {
+			height = status.SyncInfo.LatestBlockHeight
+		}
+		return err
+	})
+
+	return height, err
+}
+
 // Query performs a query for information about the connected node.
 func (ctx Context) Query(path string, data cmn.HexBytes) ([]byte, int64, error) {
 	return ctx.query(path, data)
@@ -104,34 +138,39 @@ func (ctx Context) queryAccount(addr sdk.AccAddress) ([]byte, error) {
 // query performs a query from a Tendermint node with the provided store name
 // and path.
 func (ctx *Context) query(path string, key cmn.HexBytes) (res []byte, height int64, err error) {
-	node, err := ctx.GetNode()
-	if err != nil {
-		return res, height, err
-	}
-
 	// When a client did not provide a query height, manually query for it so it can
 	// be injected downstream into responses.
 	if ctx.Height == 0 {
-		status, err := node.Status()
+		latest, err := ctx.latestHeight()
 		if err != nil {
 			return res, height, err
 		}
-		ctx.WithHeight(status.SyncInfo.LatestBlockHeight)
+		ctx.WithHeight(latest)
 	}
 
+	return ctx.queryAt(path, key, ctx.Height)
+}
+
+// queryAt performs a query at the given height and verifies the response
+// proof if the node isn't trusted.
+func (ctx *Context) queryAt(path string, key cmn.HexBytes, height int64) (res []byte, resHeight int64, err error) {
 	opts := rpcclient.ABCIQueryOptions{
-		Height: ctx.Height,
+		Height: height,
 		Prove:  !ctx.TrustNode,
 	}
 
-	result, err := node.ABCIQueryWithOptions(path, key, opts)
+	var result *ctypes.ResultABCIQuery
+	err = ctx.withNode(queryRequest, func(node rpcclient.Client) (err error) {
+		result, err = node.ABCIQueryWithOptions(path, key, opts)
+		return err
+	})
 	if err != nil {
-		return res, height, err
+		return res, resHeight, err
 	}
 
 	resp := result.Response
 	if !resp.IsOK() {
-		return res, height, errors.New(resp.Log)
+		return res, resHeight, errors.New(resp.Log)
 	}
 
 	// data from trusted node or subspace query doesn't need verification
@@ -141,7 +180,7 @@ func (ctx *Context) query(path string, key cmn.HexBytes) (res []byte, height int
 
 	err = ctx.verifyProof(path, resp)
 	if err != nil {
-		return res, height, err
+		return res, resHeight, err
 	}
 
 	return resp.Value, resp.Height, nil
@@ -149,7 +188,11 @@ func (ctx *Context) query(path string, key cmn.HexBytes) (res []byte, height int
 
 // Verify verifies the consensus proof at given height.
 func (ctx *Context) Verify(height int64) (tmtypes.SignedHeader, error) {
-	check, err := tmliteProxy.GetCertifiedCommit(height, ctx.Client, ctx.GetVerifier())
+	var check tmtypes.SignedHeader
+	err := ctx.withNode(verifyRequest, func(node rpcclient.Client) (err error) {
+		check, err = tmliteProxy.GetCertifiedCommit(height, node, ctx.GetVerifier())
+		return err
+	})
 	switch {
 	case tmliteErr.IsErrCommitNotFound(err):
 		return tmtypes.SignedHeader{}, ErrVerifyCommit(height)
@@ -0,0 +1,164 @@
+package context
+
+import (
+	gocontext "context"
+	"errors"
+	"time"
+
+	"github.com/tendermint/tendermint/libs/log"
+	rpcclient "github.com/tendermint/tendermint/rpc/client"
+)
+
+// ReadyOptions configures how NewContextWhenReady waits for the node
+type ReadyOptions struct {
+	// the delay before the first retry, doubled up to MaxBackoff after every
+	// failed attempt; zero values mean the defaults
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Logger gets failed attempts and the result; nil means no logging
+	Logger log.Logger
+}
+
+// readiness tracks a context waiting for its node
+type readiness struct {
+	done chan struct{}
+	err  error
+}
+
+var closedChan = make(chan struct{})
+
+func init() {
+	close(closedChan)
+}
+
+// NewContextWhenReady returns a context without a verifier and waits in the
+// background until the node at nodeURI responds, then creates the verifier.
+// Waiting is stopped by cancelling goCtx or by an error creating the
+// verifier. Ready is closed when waiting ends and ReadyErr returns the
+// reason it failed. Failed attempts are sent to the returned channel, if
+// there is room, and to the logger; the reason waiting failed is always
+// sent, then the channel is closed.
+func NewContextWhenReady(goCtx gocontext.Context, chainID, nodeURI, home string, opts ReadyOptions) (*Context, <-chan error, error) {
+	if nodeURI == "" {
+		return nil, nil, errors.New("no nodeURI specified")
+	}
+
+	ctx := &Context{
+		Client:        rpcclient.NewHTTP(nodeURI, "/websocket"),
+		NodeURI:       nodeURI,
+		AccountStore:  AccountStoreKey,
+		Home:          home,
+		BroadcastMode: BroadcastSync,
+	}
+
+	return ctx, ctx.startReadiness(goCtx, chainID, opts), nil
+}
+
+// startReadiness starts waiting for the node of the context
+func (ctx *Context) startReadiness(goCtx gocontext.Context, chainID string, opts ReadyOptions) <-chan error {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultReadyMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = DefaultReadyMaxBackoff
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.NewNopLogger()
+	}
+
+	r := &readiness{done: make(chan struct{})}
+	ctx.readiness = r
+
+	errs := make(chan error, 16)
+	go func() {
+		defer close(errs)
+
+		r.err = ctx.waitNode(goCtx, chainID, opts, errs)
+		if r.err != nil {
+			opts.Logger.Error("context is not ready", "node", ctx.NodeURI, "err", r.err)
+			// waitNode leaves room for it
+			errs <- r.err
+		}
+		close(r.done)
+	}()
+
+	return errs
+}
+
+// Ready returns a channel closed when the context stops waiting for its node,
+// either ready or not; ReadyErr tells which. Contexts not created by
+// NewContextWhenReady are always ready.
+func (ctx *Context) Ready() <-chan struct{} {
+	if ctx.readiness == nil {
+		return closedChan
+	}
+	return ctx.readiness.done
+}
+
+// ReadyErr returns nil if the context is ready, ErrNotReady if it is still
+// waiting for its node or the reason waiting failed
+func (ctx *Context) ReadyErr() error {
+	if ctx.readiness == nil {
+		return nil
+	}
+
+	select {
+	case <-ctx.readiness.done:
+		return ctx.readiness.err
+	default:
+		return ErrNotReady
+	}
+}
+
+// WaitReady waits until the context stops waiting for its node and returns
+// ReadyErr, or the error of goCtx if it is done first
+func (ctx *Context) WaitReady(goCtx gocontext.Context) error {
+	select {
+	case <-ctx.Ready():
+		return ctx.ReadyErr()
+	case <-goCtx.Done():
+		return goCtx.Err()
+	}
+}
+
+// waitNode polls the node status with backoff and creates the verifier.
+// Failed attempts are sent to errs while it has room for one more error.
+func (ctx *Context) waitNode(goCtx gocontext.Context, chainID string, opts ReadyOptions, errs chan<- error) error {
+	backoff := opts.MinBackoff
+	for {
+		_, err := ctx.Client.Status()
+		if err == nil {
+			break
+		}
+
+		opts.Logger.Info("node is not running", "node", ctx.NodeURI, "err", err, "retry", backoff)
+		if len(errs) < cap(errs)-1 {
+			errs <- err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-goCtx.Done():
+			return goCtx.Err()
+		}
+		if backoff *= 2; backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+
+	verifier, err := createVerifier(chainID, ctx.Home, ctx.NodeURI, verifierSource{ctx})
+	if err != nil {
+		return err
+	}
+	// the verifier is read concurrently, so it is set under the lock
+	ctx.mtx.Lock()
+	ctx.verifier = verifier
+	ctx.mtx.Unlock()
+	opts.Logger.Info("context is ready", "node", ctx.NodeURI)
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package context
+
+import (
+	gocontext "context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readyOptions() ReadyOptions {
+	return ReadyOptions{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+}
+
+// drain returns the errors sent until the channel is closed
+func drain(t *testing.T, errs <-chan error) []error {
+	var res []error
+	for {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				return res
+			}
+			res = append(res, err)
+		case <-time.After(time.Second):
+			t.Fatal("errors channel isn't closed")
+		}
+	}
+}
+
+func TestContextWhenReadyCancel(t *testing.T) {
+	node := &mockNode{status: failingStatus(connError("dial"))}
+	ctx := &Context{Client: node, NodeURI: "tcp://node:26657", Home: "home"}
+	goCtx, cancel := gocontext.WithCancel(gocontext.Background())
+
+	errs := ctx.startReadiness(goCtx, "chain", readyOptions())
+	require.True(t, isDialError(<-errs))
+	require.Equal(t, ErrNotReady, ctx.ReadyErr())
+	select {
+	case <-ctx.Ready():
+		t.Fatal("context is ready while waiting")
+	default:
+	}
+
+	// a full channel still gets the reason waiting failed
+	for node.count("status") < 20 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-ctx.Ready()
+	res := drain(t, errs)
+	require.Len(t, res, cap(errs))
+	require.Equal(t, gocontext.Canceled, res[len(res)-1])
+
+	require.Equal(t, gocontext.Canceled, ctx.ReadyErr())
+	require.Equal(t, gocontext.Canceled, ctx.WaitReady(gocontext.Background()))
+}
+
+func TestContextWhenReadyVerifierError(t *testing.T) {
+	node := &mockNode{status: statusAt(1, 0)}
+	ctx := &Context{Client: node, NodeURI: "tcp://node:26657"}
+
+	// the verifier can't be created without a home
+	errs := ctx.startReadiness(gocontext.Background(), "chain", readyOptions())
+	res := drain(t, errs)
+	require.Len(t, res, 1)
+	require.EqualError(t, res[0], "Invalid home")
+
+	require.EqualError(t, ctx.WaitReady(gocontext.Background()), "Invalid home")
+	require.Nil(t, ctx.GetVerifier())
+}
+
+func TestContextReady(t *testing.T) {
+	// contexts created otherwise are ready
+	ctx := &Context{}
+	<-ctx.Ready()
+	require.Nil(t, ctx.ReadyErr())
+	require.Nil(t, ctx.WaitReady(gocontext.Background()))
+
+	// waiting for a context is cancelled with goCtx
+	ctx = &Context{Client: &mockNode{status: failingStatus(connError("dial"))}}
+	goCtx, cancel := gocontext.WithCancel(gocontext.Background())
+	defer cancel()
+	ctx.startReadiness(goCtx, "chain", readyOptions())
+
+	waitCtx, stop := gocontext.WithTimeout(gocontext.Background(), 10*time.Millisecond)
+	defer stop()
+	require.Equal(t, gocontext.DeadlineExceeded, ctx.WaitReady(waitCtx))
+	require.Equal(t, ErrNotReady, ctx.ReadyErr())
+}
@@ -1,8 +1,19 @@
 package context
 
+import "time"
+
 const (
 	BroadcastSync   = "sync"
 	BroadcastAsync  = "async"
 	BroadcastBlock  = "block"
+	BroadcastWait   = "wait"
 	AccountStoreKey = "acc"
 )
+
+const (
+	DefaultBroadcastTimeout      = time.Minute
+	DefaultBroadcastPollInterval = time.Second
+	DefaultReadyMinBackoff       = time.Second
+	DefaultReadyMaxBackoff       = 30 * time.Second
+	DefaultDelayTimeout          = 10 * time.Minute
+)
@@ -7,4 +7,5 @@ var (
 	ErrInvalidGasAdjustment        = errors.New("invalid gas adjustment")
 	ErrInvalidSigner               = errors.New("tx intended signer does not match the given signer")
 	ErrPassphraseOrPrivKeyRequired = errors.New("passphrase or private key is required")
+	ErrTxSenderClosed              = errors.New("tx sender is closed")
 )
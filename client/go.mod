@@ -4,6 +4,7 @@ go 1.12
 
 require (
 	github.com/bgentry/speakeasy v0.1.0
+	github.com/corestario/cosmos-utils/storewrapper v0.0.0
 	github.com/cosmos/cosmos-sdk v0.28.2-0.20190827131926-5aacf454e1b6
 	github.com/mattn/go-isatty v0.0.11
 	github.com/pkg/errors v0.8.1
@@ -18,3 +19,4 @@ replace github.com/tendermint/tendermint => github.com/corestario/tendermint dev
 
 replace github.com/cosmos/cosmos-sdk => github.com/corestario/cosmos-sdk master
 
+replace github.com/corestario/cosmos-utils/storewrapper => ../storewrapper
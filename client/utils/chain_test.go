@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/corestario/cosmos-utils/client/authtypes"
+	"github.com/corestario/cosmos-utils/client/context"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// testMsg is a message sent to a stubChain
+type testMsg string
+
+func (msg testMsg) Route() string                { return "test" }
+func (msg testMsg) Type() string                 { return "test" }
+func (msg testMsg) ValidateBasic() sdk.Error     { return nil }
+func (msg testMsg) GetSignBytes() []byte         { return []byte(msg) }
+func (msg testMsg) GetSigners() []sdk.AccAddress { return nil }
+
+// stubChain is an account on a chain accepting transactions with the
+// expected sequence in sync mode
+type stubChain struct {
+	mtx sync.Mutex
+	// seq is the sequence expected by CheckTx, committed the one returned by
+	// account queries
+	seq       uint64
+	committed uint64
+	// logMismatch is the log of a transaction with a wrong sequence
+	logMismatch func(expected, got uint64) string
+	// reject, if set, can reject transactions for another reason
+	reject func(msgs []sdk.Msg) (sdk.TxResponse, error)
+	// fetchGate, if set, blocks account queries until it is closed
+	fetchGate chan struct{}
+	// broadcastGate, if set, blocks broadcasts until it is closed
+	broadcastGate chan struct{}
+
+	fetches    int
+	broadcasts []uint64
+	accepted   [][]sdk.Msg
+	gas        []uint64
+}
+
+func newStubChain(seq uint64) *stubChain {
+	return &stubChain{
+		seq:       seq,
+		committed: seq,
+		logMismatch: func(expected, got uint64) string {
+			return "signature verification failed; verify correct account sequence and chain-id"
+		},
+	}
+}
+
+func (c *stubChain) manager() *SequenceManager {
+	m := NewSequenceManager()
+	m.RetryDelay = 0
+	m.fetchAccount = c.fetch
+	m.signAndBroadcast = c.broadcast
+	return m
+}
+
+func (c *stubChain) fetch(ctx *context.Context, addr sdk.AccAddress) (uint64, uint64, error) {
+	if c.fetchGate != nil {
+		<-c.fetchGate
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.fetches++
+	return 1, c.committed, nil
+}
+
+func (c *stubChain) broadcast(txBldr authtypes.TxBuilder, ctx *context.Context, msgs []sdk.Msg) (sdk.TxResponse, error) {
+	if c.broadcastGate != nil {
+		<-c.broadcastGate
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	seq := txBldr.Sequence()
+	c.broadcasts = append(c.broadcasts, seq)
+	if seq != c.seq {
+		return sdk.TxResponse{
+			Code:      uint32(sdk.CodeUnauthorized),
+			Codespace: string(sdk.CodespaceRoot),
+			RawLog:    c.logMismatch(c.seq, seq),
+		}, nil
+	}
+	if c.reject != nil {
+		if res, err := c.reject(msgs); res.Code != 0 || err != nil {
+			return res, err
+		}
+	}
+
+	c.seq++
+	c.committed = c.seq
+	c.accepted = append(c.accepted, msgs)
+	c.gas = append(c.gas, txBldr.Gas())
+
+	return sdk.TxResponse{TxHash: fmt.Sprintf("%X", seq)}, nil
+}
+
+func (c *stubChain) state() (fetches int, broadcasts []uint64, accepted [][]sdk.Msg) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.fetches, append([]uint64{}, c.broadcasts...), append([][]sdk.Msg{}, c.accepted...)
+}
@@ -0,0 +1,250 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/corestario/cosmos-utils/client"
+	"github.com/corestario/cosmos-utils/client/authtypes"
+	"github.com/corestario/cosmos-utils/client/context"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+const (
+	DefaultSenderQueueSize = 256
+)
+
+// TxSender accepts messages from many goroutines and sends them from one,
+// so transactions are signed one at a time with sequences from a
+// SequenceManager. Messages queued at the same time can be sent together in
+// one transaction; every submission gets the result of the transaction its
+// messages were sent in.
+type TxSender struct {
+	// MaxMsgs and MaxGas limit the messages of one transaction; a submission
+	// is never split. 0 means no limit, MaxMsgs 1 disables batching.
+	MaxMsgs int
+	MaxGas  uint64
+	// GasPerMsg is the gas of submissions queued with gas 0. The gas of a
+	// transaction is the sum of its submissions unless the builder simulates
+	// transactions.
+	GasPerMsg uint64
+	// BatchDelay is how long the sender waits for more messages before a
+	// transaction is sent
+	BatchDelay time.Duration
+
+	ctx    *context.Context
+	txBldr authtypes.TxBuilder
+	seqs   *SequenceManager
+
+	mtx    sync.Mutex
+	closed bool
+	// quit is closed by Close; submitting counts Submit calls that may still
+	// send to the queue, which is closed once there are none
+	quit       chan struct{}
+	submitting sync.WaitGroup
+	queue      chan *TxFuture
+	done       chan struct{}
+}
+
+// TxFuture is the result of messages queued with TxSender.Submit
+type TxFuture struct {
+	msgs []sdk.Msg
+	gas  uint64
+
+	done chan struct{}
+	res  sdk.TxResponse
+	err  error
+}
+
+// NewTxSender returns a started sender of transactions built with txBldr and
+// signed by the from account of ctx. ctx must not be changed while the
+// sender is in use.
+func NewTxSender(ctx *context.Context, txBldr authtypes.TxBuilder, seqs *SequenceManager) *TxSender {
+	if seqs == nil {
+		seqs = NewSequenceManager()
+	}
+
+	s := &TxSender{
+		MaxMsgs: 1,
+		ctx:     ctx,
+		txBldr:  txBldr,
+		seqs:    seqs,
+		quit:    make(chan struct{}),
+		queue:   make(chan *TxFuture, DefaultSenderQueueSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+
+	return s
+}
+
+// WithBatching returns the sender putting up to maxMsgs messages and maxGas
+// gas into one transaction, waiting for up to delay for them to arrive
+func (s *TxSender) WithBatching(maxMsgs int, maxGas uint64, delay time.Duration) *TxSender {
+	s.MaxMsgs = maxMsgs
+	s.MaxGas = maxGas
+	s.BatchDelay = delay
+	return s
+}
+
+// Submit queues messages to be sent in one transaction; gas 0 means
+// GasPerMsg per message. It blocks while the queue is full, unless the
+// sender is closed meanwhile.
+func (s *TxSender) Submit(gas uint64, msgs ...sdk.Msg) *TxFuture {
+	f := &TxFuture{
+		msgs: msgs,
+		gas:  gas,
+		done: make(chan struct{}),
+	}
+
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		f.resolve(sdk.TxResponse{}, client.ErrTxSenderClosed)
+		return f
+	}
+	s.submitting.Add(1)
+	s.mtx.Unlock()
+	defer s.submitting.Done()
+
+	select {
+	case s.queue <- f:
+	case <-s.quit:
+		f.resolve(sdk.TxResponse{}, client.ErrTxSenderClosed)
+	}
+
+	return f
+}
+
+// Close stops accepting messages and returns when the queued ones are sent
+func (s *TxSender) Close() {
+	s.mtx.Lock()
+	closing := !s.closed
+	if closing {
+		s.closed = true
+		close(s.quit)
+	}
+	s.mtx.Unlock()
+
+	if closing {
+		s.submitting.Wait()
+		close(s.queue)
+	}
+	<-s.done
+}
+
+// Done returns a channel closed when the result is available
+func (f *TxFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result waits for the transaction the messages were sent in and returns
+// its response
+func (f *TxFuture) Result() (sdk.TxResponse, error) {
+	<-f.done
+	return f.res, f.err
+}
+
+func (f *TxFuture) resolve(res sdk.TxResponse, err error) {
+	f.res, f.err = res, err
+	close(f.done)
+}
+
+func (s *TxSender) run() {
+	defer close(s.done)
+
+	var next *TxFuture
+	for {
+		if next == nil {
+			var ok bool
+			if next, ok = <-s.queue; !ok {
+				return
+			}
+		}
+
+		var batch []*TxFuture
+		batch, next = s.collect(next)
+		s.send(batch)
+	}
+}
+
+// collect takes submissions from the queue for one transaction starting with
+// first; it returns the first submission that didn't fit
+func (s *TxSender) collect(first *TxFuture) (batch []*TxFuture, next *TxFuture) {
+	batch = []*TxFuture{first}
+	msgs, gas := len(first.msgs), s.gasOf(first)
+
+	var timeout <-chan time.Time
+	if s.BatchDelay > 0 {
+		timeout = time.After(s.BatchDelay)
+	}
+
+	for s.MaxMsgs != 1 {
+		var (
+			f  *TxFuture
+			ok bool
+		)
+		select {
+		case f, ok = <-s.queue:
+		default:
+			if timeout == nil {
+				return batch, nil
+			}
+			select {
+			case f, ok = <-s.queue:
+			case <-timeout:
+				return batch, nil
+			}
+		}
+		if !ok {
+			return batch, nil
+		}
+
+		if (s.MaxMsgs > 0 && msgs+len(f.msgs) > s.MaxMsgs) || (s.MaxGas > 0 && gas+s.gasOf(f) > s.MaxGas) {
+			return batch, f
+		}
+		batch = append(batch, f)
+		msgs, gas = msgs+len(f.msgs), gas+s.gasOf(f)
+	}
+
+	return batch, nil
+}
+
+// send sends a batch in one transaction. If a node rejects it for another
+// reason than its sequence and it has several submissions, they are sent one
+// by one, so a bad message doesn't fail the messages it was batched with.
+// Other errors, e.g. of the connection, are returned to every submission.
+func (s *TxSender) send(batch []*TxFuture) {
+	var (
+		msgs []sdk.Msg
+		gas  uint64
+	)
+	for _, f := range batch {
+		msgs = append(msgs, f.msgs...)
+		gas += s.gasOf(f)
+	}
+
+	txBldr := s.txBldr
+	if gas > 0 {
+		txBldr = txBldr.WithGas(gas)
+	}
+
+	res, err := s.seqs.CompleteAndBroadcastTx(txBldr, s.ctx, msgs)
+	if len(batch) > 1 && res.Code != uint32(sdk.CodeOK) && !isSequenceMismatch(res) {
+		for _, f := range batch {
+			s.send([]*TxFuture{f})
+		}
+		return
+	}
+
+	for _, f := range batch {
+		f.resolve(res, err)
+	}
+}
+
+func (s *TxSender) gasOf(f *TxFuture) uint64 {
+	if f.gas > 0 {
+		return f.gas
+	}
+	return s.GasPerMsg * uint64(len(f.msgs))
+}
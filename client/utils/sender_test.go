@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/corestario/cosmos-utils/client"
+	"github.com/corestario/cosmos-utils/client/authtypes"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxSenderBatching(t *testing.T) {
+	chain := newStubChain(0)
+	s := NewTxSender(testContext(), authtypes.TxBuilder{}, chain.manager()).
+		WithBatching(3, 0, 50*time.Millisecond)
+	s.GasPerMsg = 1000
+
+	futures := []*TxFuture{
+		s.Submit(0, testMsg("a")),
+		s.Submit(0, testMsg("b"), testMsg("c")),
+		s.Submit(500, testMsg("d")),
+	}
+	s.Close()
+
+	// a submission is never split, so the one that doesn't fit goes next
+	_, _, accepted := chain.state()
+	require.Equal(t, [][]sdk.Msg{
+		{testMsg("a"), testMsg("b"), testMsg("c")},
+		{testMsg("d")},
+	}, accepted)
+	require.Equal(t, []uint64{3000, 500}, chain.gas)
+
+	// every submission gets the response of its transaction
+	for i, hash := range []string{"0", "0", "1"} {
+		select {
+		case <-futures[i].Done():
+		default:
+			t.Fatal("future isn't resolved")
+		}
+		res, err := futures[i].Result()
+		require.Nil(t, err)
+		require.Equal(t, hash, res.TxHash)
+	}
+}
+
+func TestTxSenderRejected(t *testing.T) {
+	chain := newStubChain(0)
+	chain.reject = func(msgs []sdk.Msg) (sdk.TxResponse, error) {
+		for _, msg := range msgs {
+			if msg == testMsg("bad") {
+				return sdk.TxResponse{Code: uint32(sdk.CodeUnknownRequest), Codespace: string(sdk.CodespaceRoot)}, nil
+			}
+		}
+		return sdk.TxResponse{}, nil
+	}
+	s := NewTxSender(testContext(), authtypes.TxBuilder{}, chain.manager()).
+		WithBatching(0, 0, 50*time.Millisecond)
+
+	good, bad := s.Submit(0, testMsg("good")), s.Submit(0, testMsg("bad"))
+	s.Close()
+
+	// a rejected batch is sent again one by one
+	res, err := good.Result()
+	require.Nil(t, err)
+	require.Zero(t, res.Code)
+	res, err = bad.Result()
+	require.Nil(t, err)
+	require.Equal(t, uint32(sdk.CodeUnknownRequest), res.Code)
+	_, broadcasts, _ := chain.state()
+	require.Equal(t, []uint64{0, 0, 1}, broadcasts)
+}
+
+func TestTxSenderConnectionError(t *testing.T) {
+	chain := newStubChain(0)
+	chain.reject = func(msgs []sdk.Msg) (sdk.TxResponse, error) {
+		return sdk.TxResponse{}, errors.New("connection refused")
+	}
+	s := NewTxSender(testContext(), authtypes.TxBuilder{}, chain.manager()).
+		WithBatching(0, 0, 50*time.Millisecond)
+
+	first, second := s.Submit(0, testMsg("a")), s.Submit(0, testMsg("b"))
+	s.Close()
+
+	// the batch isn't resent message by message
+	for _, f := range []*TxFuture{first, second} {
+		_, err := f.Result()
+		require.EqualError(t, err, "connection refused")
+	}
+	_, broadcasts, _ := chain.state()
+	require.Equal(t, []uint64{0}, broadcasts)
+}
+
+func TestTxSenderClose(t *testing.T) {
+	chain := newStubChain(0)
+	chain.broadcastGate = make(chan struct{})
+	s := NewTxSender(testContext(), authtypes.TxBuilder{}, chain.manager())
+
+	// one submission is being sent and the queue is full
+	futures := []*TxFuture{s.Submit(0, testMsg("a"))}
+	for len(s.queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < DefaultSenderQueueSize; i++ {
+		futures = append(futures, s.Submit(0, testMsg("a")))
+	}
+	blocked := make(chan *TxFuture)
+	go func() {
+		blocked <- s.Submit(0, testMsg("b"))
+	}()
+
+	// the blocked submission gives up when the sender is closed
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case f := <-blocked:
+		_, err := f.Result()
+		require.Equal(t, client.ErrTxSenderClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("Submit blocked by Close")
+	}
+
+	// queued submissions are still sent
+	close(chain.broadcastGate)
+	<-closed
+	for _, f := range futures {
+		_, err := f.Result()
+		require.Nil(t, err)
+	}
+
+	_, err := s.Submit(0, testMsg("c")).Result()
+	require.Equal(t, client.ErrTxSenderClosed, err)
+}
@@ -0,0 +1,291 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/corestario/cosmos-utils/client/authtypes"
+	"github.com/corestario/cosmos-utils/client/context"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/auth/types"
+)
+
+const (
+	DefaultSequenceRetries    = 3
+	DefaultSequenceRetryDelay = time.Second
+)
+
+// SequenceManager hands out account sequences locally, so transactions of
+// one account can be broadcast by several goroutines without waiting for
+// each other to be committed. Sequences are fetched from the chain on first
+// use and synchronised again after a transaction is rejected for its
+// sequence. It is safe for concurrent use.
+type SequenceManager struct {
+	// MaxRetries is the number of times a transaction rejected for its
+	// sequence is broadcast again; RetryDelay is the pause before every retry
+	MaxRetries int
+	RetryDelay time.Duration
+
+	mtx      sync.Mutex
+	accounts map[string]*accountSequence
+
+	// fetchAccount and signAndBroadcast replace the chain in tests
+	fetchAccount     func(ctx *context.Context, addr sdk.AccAddress) (num, seq uint64, err error)
+	signAndBroadcast func(txBldr authtypes.TxBuilder, ctx *context.Context, msgs []sdk.Msg) (sdk.TxResponse, error)
+}
+
+type accountSequence struct {
+	mtx    sync.Mutex
+	synced bool
+	number uint64
+	next   uint64
+	// used is one more than the highest sequence accepted by a node;
+	// inFlight are the sequences handed out and not reported Done yet
+	used     uint64
+	inFlight map[uint64]bool
+}
+
+// expectedSequenceRe matches the sequence expected by the ante handler in
+// the log of a transaction rejected for its sequence
+var expectedSequenceRe = regexp.MustCompile(`expected (\d+)`)
+
+// NewSequenceManager returns a manager retrying DefaultSequenceRetries times
+func NewSequenceManager() *SequenceManager {
+	return &SequenceManager{
+		MaxRetries: DefaultSequenceRetries,
+		RetryDelay: DefaultSequenceRetryDelay,
+		accounts:   make(map[string]*accountSequence),
+	}
+}
+
+// Next returns the account number of addr and a sequence no other caller
+// got. The result of the transaction signed with it must be reported with
+// Done.
+func (m *SequenceManager) Next(ctx *context.Context, addr sdk.AccAddress) (num, seq uint64, err error) {
+	acc := m.account(addr)
+	acc.mtx.Lock()
+	defer acc.mtx.Unlock()
+
+	if !acc.synced {
+		// callers of other accounts and Done aren't blocked by the query
+		acc.mtx.Unlock()
+		num, seq, err := m.fetch(ctx, addr)
+		acc.mtx.Lock()
+		if err != nil {
+			return 0, 0, err
+		}
+		if !acc.synced {
+			acc.number, acc.synced = num, true
+			acc.syncTo(seq)
+		}
+	}
+
+	seq = acc.next
+	acc.next++
+	acc.inFlight[seq] = true
+
+	return acc.number, seq, nil
+}
+
+// Done reports the result of broadcasting the transaction signed with seq.
+// A sequence of a transaction rejected before it got into the mempool is
+// handed out again unless a later one was.
+func (m *SequenceManager) Done(addr sdk.AccAddress, seq uint64, res sdk.TxResponse, err error) {
+	acc := m.account(addr)
+	acc.mtx.Lock()
+	defer acc.mtx.Unlock()
+
+	delete(acc.inFlight, seq)
+	switch {
+	case isRejected(res, err):
+		if seq+1 == acc.next {
+			acc.next = seq
+		}
+	case err == nil || res.Height > 0:
+		if seq >= acc.used {
+			acc.used = seq + 1
+		}
+	}
+}
+
+// Resync fetches the sequence of addr from the chain and hands out sequences
+// from it, but not below sequences still in flight. While there are some,
+// sequences accepted by a node aren't handed out again either; otherwise
+// they may have been dropped from the mempool, and ones that weren't fail
+// with the sequence the node expects.
+func (m *SequenceManager) Resync(ctx *context.Context, addr sdk.AccAddress) error {
+	num, seq, err := m.fetch(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	acc := m.account(addr)
+	acc.mtx.Lock()
+	defer acc.mtx.Unlock()
+
+	acc.number, acc.synced = num, true
+	if len(acc.inFlight) == 0 {
+		acc.used = 0
+	}
+	if acc.used > seq {
+		seq = acc.used
+	}
+	acc.syncTo(seq)
+
+	return nil
+}
+
+// CompleteAndBroadcastTx builds, signs and broadcasts a transaction like
+// CompleteAndBroadcastTx, taking the account number and sequence from the
+// manager. If the transaction is rejected for its sequence, the manager is
+// synchronised with the sequence the chain expects, taken from the log if
+// it has one, and the transaction is signed and broadcast again up to
+// MaxRetries times.
+func (m *SequenceManager) CompleteAndBroadcastTx(txBldr authtypes.TxBuilder, ctx *context.Context, msgs []sdk.Msg) (sdk.TxResponse, error) {
+	from := ctx.GetFromAddress()
+
+	for attempt := 0; ; attempt++ {
+		num, seq, err := m.Next(ctx, from)
+		if err != nil {
+			return sdk.TxResponse{}, err
+		}
+
+		res, err := m.broadcast(txBldr.WithAccountNumber(num).WithSequence(seq), ctx, msgs)
+		m.Done(from, seq, res, err)
+		if !isSequenceMismatch(res) {
+			return res, err
+		}
+
+		if expected, ok := expectedSequence(res.RawLog); ok {
+			m.syncTo(from, expected)
+		} else if err := m.Resync(ctx, from); err != nil {
+			return res, err
+		}
+
+		if attempt >= m.MaxRetries {
+			if err == nil {
+				err = errors.New(res.RawLog)
+			}
+			return res, fmt.Errorf("sequence mismatch after %d retries: %v", attempt, err)
+		}
+		time.Sleep(m.RetryDelay)
+	}
+}
+
+func (m *SequenceManager) broadcast(txBldr authtypes.TxBuilder, ctx *context.Context, msgs []sdk.Msg) (sdk.TxResponse, error) {
+	if m.signAndBroadcast != nil {
+		return m.signAndBroadcast(txBldr, ctx, msgs)
+	}
+
+	var err error
+	if txBldr.SimulateAndExecute() {
+		if txBldr, err = EnrichWithGas(txBldr, *ctx, msgs); err != nil {
+			return sdk.TxResponse{}, err
+		}
+	}
+
+	txBytes, err := buildAndSign(txBldr, *ctx, ctx.GetFromName(), msgs)
+	if err != nil {
+		return sdk.TxResponse{}, err
+	}
+
+	return ctx.BroadcastTx(txBytes)
+}
+
+func (m *SequenceManager) fetch(ctx *context.Context, addr sdk.AccAddress) (num, seq uint64, err error) {
+	if m.fetchAccount != nil {
+		return m.fetchAccount(ctx, addr)
+	}
+	return types.NewAccountRetriever(*ctx).GetAccountNumberSequence(addr)
+}
+
+// syncTo hands out sequences of a synced account from the one expected by
+// CheckTx on. It reflects the mempool of the node, so accepted sequences from
+// expected on were dropped from it.
+func (m *SequenceManager) syncTo(addr sdk.AccAddress, expected uint64) {
+	acc := m.account(addr)
+	acc.mtx.Lock()
+	defer acc.mtx.Unlock()
+
+	if !acc.synced {
+		return
+	}
+	if acc.used > expected {
+		acc.used = expected
+	}
+	acc.syncTo(expected)
+}
+
+func (m *SequenceManager) account(addr sdk.AccAddress) *accountSequence {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.accounts == nil {
+		m.accounts = make(map[string]*accountSequence)
+	}
+
+	acc, ok := m.accounts[addr.String()]
+	if !ok {
+		acc = &accountSequence{inFlight: make(map[uint64]bool)}
+		m.accounts[addr.String()] = acc
+	}
+
+	return acc
+}
+
+// syncTo moves the next sequence to the one the chain expects, but not below
+// a sequence still in flight
+func (acc *accountSequence) syncTo(expected uint64) {
+	next := expected
+	for seq := range acc.inFlight {
+		if seq >= next {
+			next = seq + 1
+		}
+	}
+
+	acc.next = next
+}
+
+// isRejected reports whether a transaction was rejected before it got into a
+// block, so its sequence wasn't used
+func isRejected(res sdk.TxResponse, err error) bool {
+	if res.Height > 0 {
+		return false
+	}
+	return res.Code != uint32(sdk.CodeOK) || (err != nil && res.TxHash == "")
+}
+
+// isSequenceMismatch reports whether CheckTx rejected a transaction for its
+// sequence. The ante handler may not check sequences explicitly, in which
+// case a wrong sequence fails signature verification.
+func isSequenceMismatch(res sdk.TxResponse) bool {
+	if res.Codespace != "" && res.Codespace != string(sdk.CodespaceRoot) {
+		return false
+	}
+
+	switch sdk.CodeType(res.Code) {
+	case sdk.CodeInvalidSequence:
+		return true
+	case sdk.CodeUnauthorized:
+		return strings.Contains(res.RawLog, "sequence")
+	}
+
+	return false
+}
+
+// expectedSequence returns the sequence the chain expects if the log of a
+// transaction rejected for its sequence has it
+func expectedSequence(log string) (uint64, bool) {
+	match := expectedSequenceRe.FindStringSubmatch(log)
+	if match == nil {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(match[1], 10, 64)
+	return seq, err == nil
+}
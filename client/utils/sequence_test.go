@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/corestario/cosmos-utils/client/authtypes"
+	"github.com/corestario/cosmos-utils/client/context"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/stretchr/testify/require"
+)
+
+var testAddr = sdk.AccAddress("test-account-address")
+
+func testContext() *context.Context {
+	return &context.Context{FromAddress: testAddr, FromName: "test"}
+}
+
+func TestSequenceManagerNext(t *testing.T) {
+	chain := newStubChain(7)
+	m := chain.manager()
+
+	var (
+		wg   sync.WaitGroup
+		mtx  sync.Mutex
+		seqs []int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			num, seq, err := m.Next(testContext(), testAddr)
+			require.Nil(t, err)
+			require.Equal(t, uint64(1), num)
+
+			mtx.Lock()
+			seqs = append(seqs, int(seq))
+			mtx.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Ints(seqs)
+	require.Equal(t, []int{7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, seqs)
+}
+
+func TestSequenceManagerNextQuery(t *testing.T) {
+	chain := newStubChain(7)
+	chain.fetchGate = make(chan struct{})
+	m := chain.manager()
+
+	fetched := make(chan uint64)
+	go func() {
+		_, seq, _ := m.Next(testContext(), testAddr)
+		fetched <- seq
+	}()
+
+	// the account isn't locked while it is queried
+	done := make(chan struct{})
+	go func() {
+		m.Done(testAddr, 3, sdk.TxResponse{Code: 1}, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Done blocked by the account query")
+	}
+
+	close(chain.fetchGate)
+	require.Equal(t, uint64(7), <-fetched)
+}
+
+func TestSequenceManagerRetry(t *testing.T) {
+	// another client used two sequences
+	chain := newStubChain(3)
+	m := chain.manager()
+	_, _, err := m.Next(testContext(), testAddr)
+	require.Nil(t, err)
+	m.Done(testAddr, 3, sdk.TxResponse{}, nil)
+	chain.seq, chain.committed = 5, 5
+
+	res, err := m.CompleteAndBroadcastTx(authtypes.TxBuilder{}, testContext(), []sdk.Msg{testMsg("a")})
+	require.Nil(t, err)
+	require.Zero(t, res.Code)
+	fetches, broadcasts, _ := chain.state()
+	require.Equal(t, []uint64{4, 5}, broadcasts)
+	require.Equal(t, 2, fetches)
+
+	// the expected sequence is taken from the log if it has one
+	chain.logMismatch = func(expected, got uint64) string {
+		return fmt.Sprintf("account sequence mismatch, expected %d, got %d", expected, got)
+	}
+	chain.seq = 9
+	_, err = m.CompleteAndBroadcastTx(authtypes.TxBuilder{}, testContext(), []sdk.Msg{testMsg("b")})
+	require.Nil(t, err)
+	fetches, broadcasts, _ = chain.state()
+	require.Equal(t, []uint64{4, 5, 6, 9}, broadcasts)
+	require.Equal(t, 2, fetches)
+}
+
+func TestSequenceManagerRejected(t *testing.T) {
+	chain := newStubChain(3)
+	chain.reject = func(msgs []sdk.Msg) (sdk.TxResponse, error) {
+		if msgs[0] == testMsg("bad") {
+			return sdk.TxResponse{Code: uint32(sdk.CodeInsufficientFunds), Codespace: string(sdk.CodespaceRoot)}, nil
+		}
+		return sdk.TxResponse{}, nil
+	}
+	m := chain.manager()
+
+	// a transaction rejected for another reason doesn't resync and its
+	// sequence is handed out again
+	res, err := m.CompleteAndBroadcastTx(authtypes.TxBuilder{}, testContext(), []sdk.Msg{testMsg("bad")})
+	require.Nil(t, err)
+	require.Equal(t, uint32(sdk.CodeInsufficientFunds), res.Code)
+	_, err = m.CompleteAndBroadcastTx(authtypes.TxBuilder{}, testContext(), []sdk.Msg{testMsg("good")})
+	require.Nil(t, err)
+
+	fetches, broadcasts, accepted := chain.state()
+	require.Equal(t, 1, fetches)
+	require.Equal(t, []uint64{3, 3}, broadcasts)
+	require.Len(t, accepted, 1)
+}
+
+func TestSequenceManagerInFlight(t *testing.T) {
+	chain := newStubChain(3)
+	chain.logMismatch = func(expected, got uint64) string {
+		return fmt.Sprintf("account sequence mismatch, expected %d, got %d", expected, got)
+	}
+	m := chain.manager()
+	m.MaxRetries = 1
+
+	// 3 is being broadcast by someone else, so it isn't handed out again
+	// even though the chain expects it
+	_, seq, err := m.Next(testContext(), testAddr)
+	require.Nil(t, err)
+	require.Equal(t, uint64(3), seq)
+
+	_, err = m.CompleteAndBroadcastTx(authtypes.TxBuilder{}, testContext(), []sdk.Msg{testMsg("a")})
+	require.NotNil(t, err)
+	_, broadcasts, _ := chain.state()
+	require.Equal(t, []uint64{4, 4}, broadcasts)
+
+	// once it is accepted the next one goes through
+	res, err := chain.broadcast(authtypes.TxBuilder{}.WithSequence(3), testContext(), []sdk.Msg{testMsg("b")})
+	m.Done(testAddr, 3, res, err)
+	_, err = m.CompleteAndBroadcastTx(authtypes.TxBuilder{}, testContext(), []sdk.Msg{testMsg("a")})
+	require.Nil(t, err)
+	_, broadcasts, _ = chain.state()
+	require.Equal(t, []uint64{4, 4, 3, 4}, broadcasts)
+}
+
+func TestSequenceManagerEvicted(t *testing.T) {
+	chain := newStubChain(3)
+	m := chain.manager()
+	m.MaxRetries = 1
+
+	// a transaction passed CheckTx and was dropped from the mempool later
+	_, err := m.CompleteAndBroadcastTx(authtypes.TxBuilder{}, testContext(), []sdk.Msg{testMsg("a")})
+	require.Nil(t, err)
+	chain.seq, chain.committed = 3, 3
+
+	// with nothing in flight the sequence of the chain is used again
+	_, err = m.CompleteAndBroadcastTx(authtypes.TxBuilder{}, testContext(), []sdk.Msg{testMsg("b")})
+	require.Nil(t, err)
+	fetches, broadcasts, _ := chain.state()
+	require.Equal(t, []uint64{3, 4, 3}, broadcasts)
+	require.Equal(t, 2, fetches)
+
+	// so is the sequence expected by CheckTx, without a query
+	chain.logMismatch = func(expected, got uint64) string {
+		return fmt.Sprintf("account sequence mismatch, expected %d, got %d", expected, got)
+	}
+	chain.seq, chain.committed = 3, 3
+	_, err = m.CompleteAndBroadcastTx(authtypes.TxBuilder{}, testContext(), []sdk.Msg{testMsg("c")})
+	require.Nil(t, err)
+	fetches, broadcasts, _ = chain.state()
+	require.Equal(t, []uint64{3, 4, 3, 4, 3}, broadcasts)
+	require.Equal(t, 2, fetches)
+}
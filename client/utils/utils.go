@@ -61,19 +61,8 @@ func CompleteAndBroadcastTx(txBldr authtypes.TxBuilder, ctx context.Context, msg
 		return nil
 	}
 
-	if ctx.PrivKey != nil && len(ctx.PrivKey.Bytes()) != 0 {
-		if txBytes, err = txBldr.BuildAndSignWithPrivKey(ctx.PrivKey, msgs); err != nil {
-			return err
-		}
-	} else {
-		if ctx.Passphrase == "" {
-			return client.ErrPassphraseOrPrivKeyRequired
-		}
-
-		// build and sign the transaction
-		if txBytes, err = txBldr.BuildAndSign(fromName, ctx.Passphrase, msgs); err != nil {
-			return err
-		}
+	if txBytes, err = buildAndSign(txBldr, ctx, fromName, msgs); err != nil {
+		return err
 	}
 
 	// broadcast to a Tendermint node
@@ -85,6 +74,21 @@ func CompleteAndBroadcastTx(txBldr authtypes.TxBuilder, ctx context.Context, msg
 	return ctx.PrintOutput(res)
 }
 
+// buildAndSign builds a transaction and signs it with the private key of the
+// context or the key of fromName.
+func buildAndSign(txBldr authtypes.TxBuilder, ctx context.Context, fromName string, msgs []sdk.Msg) ([]byte, error) {
+	if ctx.PrivKey != nil && len(ctx.PrivKey.Bytes()) != 0 {
+		return txBldr.BuildAndSignWithPrivKey(ctx.PrivKey, msgs)
+	}
+
+	if ctx.Passphrase == "" {
+		return nil, client.ErrPassphraseOrPrivKeyRequired
+	}
+
+	// build and sign the transaction
+	return txBldr.BuildAndSign(fromName, ctx.Passphrase, msgs)
+}
+
 // EnrichWithGas calculates the gas estimate that would be consumed by the
 // transaction and set the transaction's respective value accordingly.
 func EnrichWithGas(txBldr authtypes.TxBuilder, ctx context.Context, msgs []sdk.Msg) (authtypes.TxBuilder, error) {
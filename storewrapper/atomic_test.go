@@ -0,0 +1,102 @@
+package storewrapper_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStore panics on the failAt-th write
+type failingStore struct {
+	types.KVStore
+	writes int
+	failAt int
+}
+
+func (s *failingStore) Set(key, value []byte) {
+	s.fail()
+	s.KVStore.Set(key, value)
+}
+
+func (s *failingStore) Delete(key []byte) {
+	s.fail()
+	s.KVStore.Delete(key)
+}
+
+func (s *failingStore) fail() {
+	if s.writes == s.failAt {
+		panic(fmt.Sprintf("write %d failed", s.writes))
+	}
+	s.writes++
+}
+
+func TestKVStoreAtomicSet(t *testing.T) {
+	for _, ns := range [][]byte{nil, []byte("ns/")} {
+		stw, err := makeTestStore()
+		require.Nil(t, err)
+		stw.ChunkSize = 100
+		if ns != nil {
+			stw.WithNamespace(ns)
+		}
+
+		key := []byte("Ezekiel")
+		oldValue, newValue := sampleLarge, strings.ToUpper(sampleLarge[:len(sampleLarge)/2])
+		oldParts, err := stw.SetW(key, []byte(oldValue))
+		require.Nil(t, err)
+		newParts := (len(newValue) + 99) / 100
+
+		// new chunks, manifest, old chunks
+		for failAt := 0; failAt < newParts+1+oldParts; failAt++ {
+			fs := &failingStore{KVStore: stw.KVStore, failAt: failAt}
+			failing := storewrapper.NewKVStore(fs, 100)
+			if ns != nil {
+				failing.WithNamespace(ns)
+			}
+
+			_, err := failing.SetW(key, []byte(newValue))
+			require.NotNil(t, err)
+
+			res, err := stw.GetW(key)
+			require.Nil(t, err)
+			if failAt <= newParts {
+				require.Equal(t, oldValue, string(res), "fail at %d", failAt)
+			} else {
+				require.Equal(t, newValue, string(res), "fail at %d", failAt)
+			}
+
+			_, err = stw.SetW(key, []byte(oldValue))
+			require.Nil(t, err)
+		}
+	}
+}
+
+func TestKVStoreAtomicDelete(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	key := []byte("Ezekiel")
+	parts, err := stw.SetW(key, []byte(sampleLarge))
+	require.Nil(t, err)
+
+	for failAt := 0; failAt < parts+1; failAt++ {
+		fs := &failingStore{KVStore: stw.KVStore, failAt: failAt}
+		_, err := storewrapper.NewKVStore(fs, 100).DeleteW(key)
+		require.NotNil(t, err)
+
+		res, err := stw.GetW(key)
+		require.Nil(t, err)
+		if failAt == 0 {
+			require.Equal(t, sampleLarge, string(res))
+		} else {
+			require.Empty(t, res)
+		}
+
+		_, err = stw.SetW(key, []byte(sampleLarge))
+		require.Nil(t, err)
+	}
+}
@@ -0,0 +1,128 @@
+package storewrapper
+
+import (
+	"sort"
+
+	"github.com/cosmos/cosmos-sdk/store/cachekv"
+)
+
+// Batch collects writes of several values and applies them together
+type Batch struct {
+	k   *KVStore
+	ops []batchOp
+}
+
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// NewBatch returns an empty batch of writes to the wrapper
+func (k *KVStore) NewBatch() *Batch {
+	return &Batch{k: k}
+}
+
+// Set adds SetW of a value to the batch
+func (b *Batch) Set(keySrc, valueSrc []byte) *Batch {
+	b.ops = append(b.ops, batchOp{key: keySrc, value: valueSrc})
+	return b
+}
+
+// Delete adds DeleteW of a value to the batch
+func (b *Batch) Delete(keySrc []byte) *Batch {
+	b.ops = append(b.ops, batchOp{key: keySrc, delete: true})
+	return b
+}
+
+// Len returns the number of writes in the batch
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// WriteW applies the writes in the order they were added; return parts
+// written or deleted by every write, error. The writes go to a cache branch
+// of the store that is written only if all of them succeed, so on error the
+// store is left untouched and the error is a *BatchError of the first
+// failed write.
+func (b *Batch) WriteW() (parts []int, err error) {
+	o := b.k.observe(OpBatch, nil)
+	defer o.done(&err)
+	defer recoverError(&err)
+	defer b.k.trackOps()()
+
+	// writes in the branch are reported as a part of the batch
+	cache := cachekv.NewStore(b.k.KVStore)
+	branch := *b.k
+	branch.KVStore = cache
+	branch.Listener = nil
+	branch.Cache = b.k.Cache.Branch()
+
+	parts = make([]int, len(b.ops))
+	for i, op := range b.ops {
+		if op.delete {
+			parts[i], err = branch.DeleteW(op.key)
+		} else {
+			parts[i], err = branch.SetW(op.key, op.value)
+		}
+		if err != nil {
+			return nil, &BatchError{Key: op.key, Index: i, Err: err}
+		}
+		o.set(len(op.value), parts[i])
+	}
+
+	cache.Write()
+	branch.Cache.Write()
+
+	return parts, nil
+}
+
+// SetManyW writes several values at once in the order of their keys; return
+// parts written per key, error. Like Batch.WriteW the writes are
+// all-or-nothing.
+func (k *KVStore) SetManyW(values map[string][]byte) (parts map[string]int, err error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	b := k.NewBatch()
+	for _, key := range keys {
+		b.Set([]byte(key), values[key])
+	}
+
+	written, err := b.WriteW()
+	if err != nil {
+		return nil, err
+	}
+
+	parts = make(map[string]int, len(keys))
+	for i, key := range keys {
+		parts[key] = written[i]
+	}
+
+	return parts, nil
+}
+
+// GetManyW returns the values of several keys in the order of keys; a
+// missing value is empty. On error it is a *BatchError of the first key that
+// failed.
+func (k *KVStore) GetManyW(keys ...[]byte) (values [][]byte, err error) {
+	o := k.observe(OpGetMany, nil)
+	defer o.done(&err)
+	defer recoverError(&err)
+	defer k.trackOps()()
+
+	values = make([][]byte, len(keys))
+	for i, key := range keys {
+		var parts int
+		values[i], parts, _, err = k.get(key)
+		if err != nil {
+			return nil, &BatchError{Key: key, Index: i, Err: err}
+		}
+		o.set(len(values[i]), parts)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,63 @@
+package storewrapper_test
+
+import (
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStoreBatch(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.WithNamespace([]byte("ns/")).ChunkSize = 100
+
+	parts, err := stw.SetManyW(map[string][]byte{
+		"Ezekiel": []byte(sampleLarge),
+		"Jules":   []byte(sampleSmall),
+	})
+	require.Nil(t, err)
+	require.Equal(t, map[string]int{"Ezekiel": (len(sampleLarge) + 99) / 100, "Jules": 2}, parts)
+
+	values, err := stw.GetManyW([]byte("Jules"), []byte("missing"), []byte("Ezekiel"))
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte(sampleSmall), {}, []byte(sampleLarge)}, values)
+
+	written, err := stw.NewBatch().
+		Set([]byte("Vincent"), []byte(sampleSmall)).
+		Delete([]byte("Ezekiel")).
+		Set([]byte("Jules"), []byte(sampleLarge[:100])).
+		WriteW()
+	require.Nil(t, err)
+	require.Equal(t, []int{2, (len(sampleLarge) + 99) / 100, 1}, written)
+
+	values, err = stw.GetManyW([]byte("Ezekiel"), []byte("Jules"), []byte("Vincent"))
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{{}, []byte(sampleLarge[:100]), []byte(sampleSmall)}, values)
+}
+
+func TestKVStoreBatchAtomic(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.WithNamespace([]byte("ns/")).WithMaxValueSize(len(sampleSmall)).ChunkSize = 100
+
+	_, err = stw.SetW([]byte("Jules"), []byte(sampleSmall))
+	require.Nil(t, err)
+	raw := countRaw(stw)
+
+	_, err = stw.NewBatch().
+		Delete([]byte("Jules")).
+		Set([]byte("Vincent"), []byte(sampleSmall)).
+		Set([]byte("Ezekiel"), []byte(sampleLarge)).
+		WriteW()
+	require.IsType(t, &storewrapper.BatchError{}, err)
+	require.Equal(t, 2, err.(*storewrapper.BatchError).Index)
+	require.Equal(t, []byte("Ezekiel"), err.(*storewrapper.BatchError).Key)
+	require.IsType(t, &storewrapper.ValueTooLargeError{}, err.(*storewrapper.BatchError).Err)
+
+	// nothing was written
+	require.Equal(t, raw, countRaw(stw))
+	values, err := stw.GetManyW([]byte("Jules"), []byte("Vincent"))
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte(sampleSmall), {}}, values)
+}
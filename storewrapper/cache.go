@@ -0,0 +1,246 @@
+package storewrapper
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/cosmos/cosmos-sdk/store/types"
+)
+
+// ValueCache keeps recently read values in memory, so repeated reads of a
+// large value don't load and reassemble its chunks. It is filled by reads
+// and invalidated by writes of the wrappers using it, and evicts the least
+// recently used values when they take more than its budget.
+//
+// A wrapper over a cache branch of the store, e.g. cachekv.NewStore or the
+// store of a CacheContext, must use a Branch of the cache, so values read
+// from writes that may be discarded never reach it; Batch does so itself.
+// The cache must be Reset when the store is rolled back or changed
+// otherwise than through the wrappers using it.
+//
+// Cached reads don't touch the store. Wrappers created WithGasMeter consume
+// the gas of the reads a hit replaces, so gas doesn't depend on the content
+// of the cache; without a gas meter the cache must not be used in the state
+// machine, only e.g. in queries.
+type ValueCache struct {
+	mu      sync.Mutex
+	budget  int
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+	stats   CacheStats
+
+	// a branch reads through parent the keys it didn't write
+	parent  *ValueCache
+	written map[string]bool
+}
+
+// CacheStats are the statistics of a ValueCache since it was created
+type CacheStats struct {
+	Hits      int
+	Misses    int
+	Evictions int
+
+	// Values and Bytes describe the current content
+	Values int
+	Bytes  int
+}
+
+type cacheEntry struct {
+	key   string
+	value []byte
+	parts int
+	// sizes of the values read from the store to load the value, -1 for Has
+	reads []int
+}
+
+// NewValueCache returns a cache keeping up to budget bytes of keys and values
+func NewValueCache(budget int) *ValueCache {
+	return &ValueCache{
+		budget:  budget,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// WithCache returns the wrapper reading through c; nil disables caching. A
+// cache must not be shared by wrappers with different namespaces or
+// underlying stores.
+func (k *KVStore) WithCache(c *ValueCache) *KVStore {
+	k.Cache = c
+	return k
+}
+
+// WithGasMeter returns the wrapper consuming gas from meter with cfg for the
+// reads replaced by cache hits, e.g. ctx.GasMeter() and types.KVGasConfig()
+// for a store obtained from ctx. Other operations consume gas through the
+// underlying store.
+func (k *KVStore) WithGasMeter(meter types.GasMeter, cfg types.GasConfig) *KVStore {
+	k.GasMeter, k.GasConfig = meter, cfg
+	return k
+}
+
+// Branch returns a cache for a wrapper over a cache branch of the store c is
+// used with. Values read through the branch stay in it, keys the branch
+// doesn't write are also read from c. Write must be called when the store
+// branch is written; a discarded branch is just dropped.
+func (c *ValueCache) Branch() *ValueCache {
+	if c == nil {
+		return nil
+	}
+
+	b := NewValueCache(c.budget)
+	b.parent = c
+	b.written = make(map[string]bool)
+	return b
+}
+
+// Write drops the values written in the branch from its parent, so they are
+// read again from the written store
+func (c *ValueCache) Write() {
+	if c == nil || c.parent == nil {
+		return
+	}
+	c.mu.Lock()
+	written := c.written
+	c.written = make(map[string]bool)
+	c.mu.Unlock()
+
+	for key := range written {
+		c.parent.invalidate([]byte(key))
+	}
+}
+
+// Stats returns the statistics of the cache
+func (c *ValueCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Values, stats.Bytes = c.lru.Len(), c.size
+	return stats
+}
+
+// Reset drops all cached values; statistics are kept
+func (c *ValueCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+}
+
+// get returns a copy of a cached value, its number of parts and the reads
+// it replaces
+func (c *ValueCache) get(keySrc []byte) ([]byte, int, []int, bool) {
+	if c == nil {
+		return nil, 0, nil, false
+	}
+
+	e, ok := c.lookup(keySrc)
+	c.mu.Lock()
+	if ok {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, 0, nil, false
+	}
+
+	return append([]byte{}, e.value...), e.parts, e.reads, true
+}
+
+// lookup finds the entry of a key in the cache or, for a key a branch didn't
+// write, in its parent
+func (c *ValueCache) lookup(keySrc []byte) (*cacheEntry, bool) {
+	c.mu.Lock()
+	el, ok := c.entries[string(keySrc)]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	throughParent := !ok && c.parent != nil && !c.written[string(keySrc)]
+	c.mu.Unlock()
+
+	if ok {
+		return el.Value.(*cacheEntry), true
+	}
+	if throughParent {
+		return c.parent.lookup(keySrc)
+	}
+	return nil, false
+}
+
+// put caches a copy of a value read from the store with the given reads;
+// values larger than the budget are not cached
+func (c *ValueCache) put(keySrc, value []byte, parts int, reads []int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(string(keySrc))
+
+	e := &cacheEntry{key: string(keySrc), value: append([]byte{}, value...), parts: parts, reads: reads}
+	if e.size() > c.budget {
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size()
+
+	for c.size > c.budget {
+		c.remove(c.lru.Back().Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops the cached value of a key; a branch stops reading it
+// from its parent
+func (c *ValueCache) invalidate(keySrc []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(string(keySrc))
+	if c.parent != nil {
+		c.written[string(keySrc)] = true
+	}
+}
+
+// replayReads consumes the gas of the reads replaced by a cache hit the same
+// way the underlying store would
+func (k *KVStore) replayReads(reads []int) {
+	if k.GasMeter == nil {
+		return
+	}
+
+	cfg := k.GasConfig
+	for _, n := range reads {
+		if n < 0 {
+			k.GasMeter.ConsumeGas(cfg.HasCost, types.GasHasDesc)
+			continue
+		}
+		k.GasMeter.ConsumeGas(cfg.ReadCostFlat, types.GasReadCostFlatDesc)
+		k.GasMeter.ConsumeGas(cfg.ReadCostPerByte*types.Gas(n), types.GasReadPerByteDesc)
+	}
+}
+
+func (c *ValueCache) remove(key string) {
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	c.lru.Remove(el)
+	delete(c.entries, key)
+	c.size -= el.Value.(*cacheEntry).size()
+}
+
+func (e *cacheEntry) size() int {
+	return len(e.key) + len(e.value)
+}
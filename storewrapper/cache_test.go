@@ -0,0 +1,170 @@
+package storewrapper_test
+
+import (
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/cosmos/cosmos-sdk/store/cachekv"
+	"github.com/cosmos/cosmos-sdk/store/gaskv"
+	"github.com/cosmos/cosmos-sdk/store/types"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStoreCache(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	cache := storewrapper.NewValueCache(1024 * 1024)
+	stw.WithNamespace([]byte("ns/")).WithCache(cache).ChunkSize = 100
+
+	_, err = stw.SetW([]byte("Ezekiel"), []byte(sampleLarge))
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		res, err := stw.GetW([]byte("Ezekiel"))
+		require.Nil(t, err)
+		require.Equal(t, sampleLarge, string(res))
+	}
+	require.Equal(t, storewrapper.Ops{}, stw.LastOps())
+	require.Equal(t, storewrapper.CacheStats{
+		Hits:   2,
+		Misses: 1,
+		Values: 1,
+		Bytes:  len("Ezekiel") + len(sampleLarge),
+	}, cache.Stats())
+
+	// returned values don't share memory with the cache
+	res, err := stw.GetW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	res[0] = 'X'
+	res, err = stw.GetW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+
+	// writes invalidate the cached value
+	_, err = stw.AppendW([]byte("Ezekiel"), []byte(sampleSmall))
+	require.Nil(t, err)
+	res, err = stw.GetW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge+sampleSmall, string(res))
+
+	_, err = stw.SetW([]byte("Ezekiel"), []byte(sampleSmall))
+	require.Nil(t, err)
+	res, err = stw.GetW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	require.Equal(t, sampleSmall, string(res))
+
+	_, err = stw.DeleteW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	res, err = stw.GetW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	require.Empty(t, res)
+	require.Zero(t, cache.Stats().Values)
+}
+
+func TestKVStoreCacheBudget(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	cache := storewrapper.NewValueCache(2 * (len(sampleSmall) + 1))
+	stw.WithCache(cache)
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		_, err = stw.SetW([]byte(key), []byte(sampleSmall))
+		require.Nil(t, err)
+	}
+	_, err = stw.SetW([]byte("large"), []byte(sampleLarge))
+	require.Nil(t, err)
+
+	for _, key := range []string{"a", "b", "a", "c", "a", "large"} {
+		_, err = stw.GetW([]byte(key))
+		require.Nil(t, err)
+	}
+
+	// "b" was the least recently used, "large" doesn't fit
+	stats := cache.Stats()
+	require.Equal(t, 2, stats.Hits)
+	require.Equal(t, 4, stats.Misses)
+	require.Equal(t, 1, stats.Evictions)
+	require.Equal(t, 2, stats.Values)
+	require.Equal(t, 2*(len(sampleSmall)+1), stats.Bytes)
+
+	_, err = stw.GetW([]byte("a"))
+	require.Nil(t, err)
+	require.Equal(t, 3, cache.Stats().Hits)
+}
+
+func TestKVStoreCacheBranch(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	cache := storewrapper.NewValueCache(1024 * 1024)
+	stw.WithCache(cache)
+
+	_, err = stw.SetW([]byte("Jules"), []byte(sampleSmall))
+	require.Nil(t, err)
+	_, err = stw.SetW([]byte("Vincent"), []byte(sampleSmall))
+	require.Nil(t, err)
+	_, err = stw.GetW([]byte("Vincent"))
+	require.Nil(t, err)
+
+	// a value written and read in a branch that is discarded doesn't reach
+	// the cache, values the branch didn't write are read from it
+	store := cachekv.NewStore(stw.KVStore)
+	branch := storewrapper.NewKVStore(store, 0).WithCache(cache.Branch())
+	_, err = branch.SetW([]byte("Jules"), []byte(sampleLarge))
+	require.Nil(t, err)
+	res, err := branch.GetW([]byte("Jules"))
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+	res, err = branch.GetW([]byte("Vincent"))
+	require.Nil(t, err)
+	require.Equal(t, sampleSmall, string(res))
+	require.Equal(t, 1, branch.Cache.Stats().Hits)
+
+	res, err = stw.GetW([]byte("Jules"))
+	require.Nil(t, err)
+	require.Equal(t, sampleSmall, string(res))
+
+	// once the branch is written the cache reads the value again
+	store.Write()
+	branch.Cache.Write()
+	res, err = stw.GetW([]byte("Jules"))
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+
+	// so do failed batches
+	_, err = stw.WithMaxValueSize(len(sampleSmall)).NewBatch().Set([]byte("Jules"), []byte(sampleSmall)).
+		Set([]byte("Vincent"), []byte(sampleLarge)).WriteW()
+	require.NotNil(t, err)
+	stw.WithMaxValueSize(0)
+	res, err = stw.GetW([]byte("Jules"))
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+	require.Equal(t, 3, cache.Stats().Misses)
+}
+
+func TestKVStoreCacheGas(t *testing.T) {
+	base, err := makeTestStore()
+	require.Nil(t, err)
+	meter := sdk.NewInfiniteGasMeter()
+	cfg := types.KVGasConfig()
+	stw := storewrapper.NewKVStore(gaskv.NewStore(base.KVStore, meter, cfg), 100).
+		WithCache(storewrapper.NewValueCache(1024*1024)).WithGasMeter(meter, cfg)
+
+	_, err = stw.SetW([]byte("Ezekiel"), []byte(sampleLarge))
+	require.Nil(t, err)
+	stw.Set(rawChunkKey([]byte("legacy"), 0, 0), []byte(sampleSmall))
+
+	// a hit consumes the gas of the read it replaces
+	for _, key := range []string{"Ezekiel", "legacy"} {
+		var gas []uint64
+		for i := 0; i < 2; i++ {
+			before := meter.GasConsumed()
+			_, err = stw.GetW([]byte(key))
+			require.Nil(t, err)
+			gas = append(gas, meter.GasConsumed()-before)
+		}
+		require.NotZero(t, gas[0])
+		require.Equal(t, gas[0], gas[1])
+	}
+	require.Equal(t, 2, stw.Cache.Stats().Hits)
+}
@@ -0,0 +1,460 @@
+package storewrapper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// AnomalyKind classifies an anomaly found by CheckW
+type AnomalyKind string
+
+const (
+	AnomalyBadManifest     AnomalyKind = "bad_manifest"
+	AnomalyMissingChunk    AnomalyKind = "missing_chunk"
+	AnomalyBadChunk        AnomalyKind = "bad_chunk"
+	AnomalyBadValue        AnomalyKind = "bad_value"
+	AnomalyMissingVersion  AnomalyKind = "missing_version"
+	AnomalyOrphanedChunk   AnomalyKind = "orphaned_chunk"
+	AnomalyOrphanedVersion AnomalyKind = "orphaned_version"
+	AnomalyOrphanedBlob    AnomalyKind = "orphaned_blob"
+	AnomalyRefCount        AnomalyKind = "ref_count"
+	AnomalyUnknownRecord   AnomalyKind = "unknown_record"
+)
+
+// Anomaly is an inconsistency of the stored records. Key is the logical key
+// or the hash of a deduplicated chunk, RawKey the record at fault if there is
+// one and Chunk the index of the bad chunk or -1.
+type Anomaly struct {
+	Kind   AnomalyKind
+	Key    []byte
+	RawKey []byte
+	Chunk  int
+	Detail string
+}
+
+func (a Anomaly) String() string {
+	s := fmt.Sprintf("%s: key %X", a.Kind, a.Key)
+	if a.Chunk >= 0 {
+		s += fmt.Sprintf(", chunk %d", a.Chunk)
+	}
+	if a.Detail != "" {
+		s += ": " + a.Detail
+	}
+	return s
+}
+
+// CheckReport is the result of CheckW
+type CheckReport struct {
+	Values    int // values with a manifest
+	Versions  int // older versions of the values
+	Chunks    int // chunks of the values and versions
+	Anomalies []Anomaly
+	Repaired  int // records deleted or rewritten
+}
+
+// OK reports whether no anomalies were found
+func (r *CheckReport) OK() bool {
+	return len(r.Anomalies) == 0
+}
+
+func (r *CheckReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d values, %d versions, %d chunks, %d anomalies, %d records repaired",
+		r.Values, r.Versions, r.Chunks, len(r.Anomalies), r.Repaired)
+	for _, a := range r.Anomalies {
+		sb.WriteString("\n")
+		sb.WriteString(a.String())
+	}
+	return sb.String()
+}
+
+// CheckW scans all records of the wrapper, i.e. its namespace or the whole
+// underlying store without one, and reports undecodable manifests, missing
+// or corrupted chunks, missing versions, orphaned records and wrong reference
+// counts. Without a namespace only records of keys having a manifest are
+// checked, as other records can't be told from foreign data. Chunks are
+// checked against the manifest, but values aren't decompressed or decrypted.
+//
+// With repair set, orphaned records are deleted, broken values are truncated
+// to their longest intact prefix or deleted if they are compressed or
+// encrypted, versions older than a missing one are dropped and reference
+// counts are fixed. Records are visited in key order, so a repair is
+// deterministic and can run in the state machine, e.g. in an upgrade handler.
+// Only values in a namespace can be repaired, otherwise ErrRepairNoNamespace
+// is returned: records of other data may look like orphaned chunks. Legacy
+// values can be moved into a namespace with MigrateLegacyW first.
+func (k *KVStore) CheckW(repair bool) (report *CheckReport, err error) {
+	defer recoverError(&err)
+
+	if _, namespaced := k.Namespace(); repair && !namespaced {
+		return nil, ErrRepairNoNamespace
+	}
+
+	c := &checker{
+		k:        k,
+		repair:   repair,
+		report:   &CheckReport{},
+		known:    map[string]bool{},
+		expected: map[string]bool{},
+		refs:     map[string]uint64{},
+		dropped:  map[string]bool{},
+		released: map[string]uint64{},
+	}
+	c.scan()
+	for _, rec := range c.manifests {
+		c.checkValue(rec)
+	}
+	c.checkOrphans()
+
+	// repaired values may have been cached
+	if c.report.Repaired > 0 && k.Cache != nil {
+		k.Cache.Reset()
+	}
+
+	return c.report, nil
+}
+
+// Invariant returns an invariant that is broken if CheckW finds anomalies in
+// the wrapper returned by store
+func Invariant(module, route string, store func(ctx sdk.Context) *KVStore) sdk.Invariant {
+	return func(ctx sdk.Context) (string, bool) {
+		report, err := store(ctx).CheckW(false)
+		if err != nil {
+			return sdk.FormatInvariant(module, route, err.Error()), true
+		}
+		return sdk.FormatInvariant(module, route, report.String()), !report.OK()
+	}
+}
+
+// checker holds the state of CheckW
+type checker struct {
+	k      *KVStore
+	repair bool
+	report *CheckReport
+
+	// records found by scan in key order; blobs and refs hold hashes
+	manifests []scanned
+	chunks    []scanned
+	versions  []scanned
+	blobs     [][]byte
+	refCounts [][]byte
+
+	known    map[string]bool   // logical keys with a manifest record
+	expected map[string]bool   // raw keys of chunks and versions in use
+	refs     map[string]uint64 // expected references of deduplicated chunks
+
+	// records and references dropped by the repair of broken values
+	dropped  map[string]bool
+	released map[string]uint64
+}
+
+type scanned struct {
+	raw   []byte
+	rec   record
+	value []byte
+}
+
+func (c *checker) scan() {
+	_, namespaced := c.k.Namespace()
+	start, end := c.k.keys().rawRange(nil, nil)
+
+	it := c.k.KVStore.Iterator(start, end)
+	defer it.Close()
+
+	for ; it.Valid(); it.Next() {
+		raw := concat(it.Key())
+		rec, ok := c.k.keys().record(raw)
+		if !ok {
+			if namespaced {
+				c.anomaly(Anomaly{Kind: AnomalyUnknownRecord, RawKey: raw, Chunk: -1})
+			}
+			continue
+		}
+
+		s := scanned{raw: raw, rec: rec}
+		switch rec.kind {
+		case recordManifest:
+			s.value = concat(it.Value())
+			c.manifests = append(c.manifests, s)
+			c.known[string(rec.key)] = true
+		case recordChunk:
+			c.chunks = append(c.chunks, s)
+		case recordVersion:
+			c.versions = append(c.versions, s)
+		case recordBlob:
+			c.blobs = append(c.blobs, rec.key)
+		case recordRef:
+			c.refCounts = append(c.refCounts, rec.key)
+		}
+	}
+}
+
+// checkValue checks a value and its versions and registers the records they use
+func (c *checker) checkValue(s scanned) {
+	key := s.rec.key
+	m, err := decodeManifest(s.value)
+	if err == nil && m.Version > ManifestVersion {
+		err = fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	if err != nil {
+		c.anomaly(Anomaly{Kind: AnomalyBadManifest, Key: key, RawKey: s.raw, Chunk: -1, Detail: err.Error()})
+		c.delete(s.raw)
+		return
+	}
+	c.report.Values++
+
+	// a missing version drops the versions before it
+	type version struct {
+		v         uint64
+		m         manifest
+		truncated manifest
+	}
+	var versions []version
+	oldest := m.OldestVersion
+	for v := m.OldestVersion; v < m.ValueVersion; v++ {
+		where := fmt.Sprintf("version %d", v)
+		raw := c.k.keys().versionKey(key, v)
+		bz := c.k.Get(raw)
+		if bz == nil {
+			c.anomaly(Anomaly{Kind: AnomalyMissingVersion, Key: key, RawKey: raw, Chunk: -1, Detail: where})
+			oldest = v + 1
+			continue
+		}
+
+		old, err := decodeManifest(bz)
+		if err != nil {
+			c.anomaly(Anomaly{Kind: AnomalyBadManifest, Key: key, RawKey: raw, Chunk: -1, Detail: where + ": " + err.Error()})
+			oldest = v + 1
+			continue
+		}
+		c.report.Versions++
+
+		truncated, ok := c.checkChunks(key, old, where)
+		if !ok && !truncatable(old) {
+			oldest = v + 1
+		}
+		versions = append(versions, version{v: v, m: old, truncated: truncated})
+	}
+	truncated, ok := c.checkChunks(key, m, "")
+
+	if !c.repair {
+		for _, old := range versions {
+			c.expected[string(c.k.keys().versionKey(key, old.v))] = true
+			c.expect(key, old.m)
+		}
+		c.expect(key, m)
+		return
+	}
+
+	if !ok && !truncatable(m) {
+		oldest = m.ValueVersion
+		c.delete(s.raw)
+		c.release(key, m, 0)
+	} else {
+		if !ok || oldest != m.OldestVersion {
+			truncated.OldestVersion = oldest
+			c.set(s.raw, truncated.bytes())
+		}
+		c.expect(key, truncated)
+		c.release(key, m, truncated.Chunks)
+	}
+
+	for _, old := range versions {
+		raw := c.k.keys().versionKey(key, old.v)
+		if old.v < oldest {
+			c.dropped[string(raw)] = true
+			c.release(key, old.m, 0)
+			continue
+		}
+
+		if old.truncated.Chunks != old.m.Chunks || !bytes.Equal(old.truncated.Hash, old.m.Hash) {
+			c.set(raw, old.truncated.bytes())
+		}
+		c.expected[string(raw)] = true
+		c.expect(key, old.truncated)
+		c.release(key, old.m, old.truncated.Chunks)
+	}
+}
+
+// checkChunks checks the chunks of the value described by m; it returns the
+// manifest of its intact prefix and whether the value is intact
+func (c *checker) checkChunks(key []byte, m manifest, where string) (manifest, bool) {
+	hash := sha256.New()
+	for i := uint64(0); i < m.Chunks; i++ {
+		raw := c.k.chunkKey(key, m, i)
+		ba := c.k.Get(raw)
+
+		err := m.verifyChunk(key, i, ba)
+		if err == nil && uint64(len(ba)) != m.chunkLen(i) {
+			err = errCorrupted(key, int(i), "length %d, expected %d", len(ba), m.chunkLen(i))
+		}
+		if err != nil {
+			kind := AnomalyBadChunk
+			if ba == nil {
+				kind = AnomalyMissingChunk
+			}
+			c.anomaly(Anomaly{Kind: kind, Key: key, RawKey: raw, Chunk: int(i), Detail: detail(where, err)})
+			return truncate(m, i, hash.Sum(nil)), false
+		}
+
+		hash.Write(ba)
+	}
+
+	if len(m.Hash) > 0 && !bytes.Equal(hash.Sum(nil), m.Hash) {
+		err := errCorrupted(key, -1, "checksum mismatch")
+		c.anomaly(Anomaly{Kind: AnomalyBadValue, Key: key, Chunk: -1, Detail: detail(where, err)})
+		return truncate(m, 0, hashOf(nil)), false
+	}
+
+	return m, true
+}
+
+// expect registers the chunks of the value described by m as used
+func (c *checker) expect(key []byte, m manifest) {
+	c.report.Chunks += int(m.Chunks)
+	for i := uint64(0); i < m.Chunks; i++ {
+		if m.Dedup {
+			c.refs[string(m.ChunkHashes[i])]++
+		} else {
+			c.expected[string(c.k.chunkKey(key, m, i))] = true
+		}
+	}
+}
+
+// release registers the chunks of the value described by m starting from
+// chunk from as dropped by the repair
+func (c *checker) release(key []byte, m manifest, from uint64) {
+	for i := from; i < m.Chunks; i++ {
+		if m.Dedup {
+			c.released[string(m.ChunkHashes[i])]++
+		} else {
+			c.dropped[string(c.k.chunkKey(key, m, i))] = true
+		}
+	}
+}
+
+// checkOrphans reports records no value uses and wrong reference counts
+func (c *checker) checkOrphans() {
+	_, namespaced := c.k.Namespace()
+	// records dropped by the repair are deleted without being reported
+	orphan := func(s scanned) bool {
+		if c.expected[string(s.raw)] || !namespaced && !c.known[string(s.rec.key)] {
+			return false
+		}
+		if c.dropped[string(s.raw)] {
+			c.delete(s.raw)
+			return false
+		}
+		return true
+	}
+
+	for _, s := range c.chunks {
+		if orphan(s) {
+			c.anomaly(Anomaly{Kind: AnomalyOrphanedChunk, Key: s.rec.key, RawKey: s.raw, Chunk: int(uint32(s.rec.n))})
+			c.delete(s.raw)
+		}
+	}
+	for _, s := range c.versions {
+		if orphan(s) {
+			c.anomaly(Anomaly{Kind: AnomalyOrphanedVersion, Key: s.rec.key, RawKey: s.raw, Chunk: -1,
+				Detail: fmt.Sprintf("version %d", s.rec.n)})
+			c.delete(s.raw)
+		}
+	}
+
+	if !namespaced {
+		return
+	}
+
+	// reference counts without a chunk come after all chunks
+	hashes, blobs := c.blobs, map[string]bool{}
+	for _, hash := range c.blobs {
+		blobs[string(hash)] = true
+	}
+	for _, hash := range c.refCounts {
+		if !blobs[string(hash)] {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	for _, hash := range hashes {
+		expected, released := c.refs[string(hash)], c.released[string(hash)]
+		blobKey, refKey := c.k.keys().blobKey(hash), c.k.keys().refKey(hash)
+
+		bz := c.k.Get(refKey)
+		if len(bz) != 8 || binary.BigEndian.Uint64(bz) != expected+released {
+			if expected+released == 0 {
+				c.anomaly(Anomaly{Kind: AnomalyOrphanedBlob, Key: hash, RawKey: blobKey, Chunk: -1})
+			} else {
+				c.anomaly(Anomaly{Kind: AnomalyRefCount, Key: hash, RawKey: refKey, Chunk: -1,
+					Detail: fmt.Sprintf("%X references stored, %d expected", bz, expected+released)})
+			}
+		}
+
+		switch {
+		case expected == 0:
+			c.delete(blobKey)
+			c.delete(refKey)
+		case len(bz) != 8 || binary.BigEndian.Uint64(bz) != expected:
+			c.set(refKey, refsBytes(expected))
+		}
+	}
+}
+
+func (c *checker) anomaly(a Anomaly) {
+	c.report.Anomalies = append(c.report.Anomalies, a)
+}
+
+func (c *checker) delete(raw []byte) {
+	if c.repair && c.k.Has(raw) {
+		c.k.Delete(raw)
+		c.report.Repaired++
+	}
+}
+
+func (c *checker) set(raw, value []byte) {
+	if c.repair {
+		c.k.Set(raw, value)
+		c.report.Repaired++
+	}
+}
+
+// truncatable reports whether a prefix of the value described by m is a
+// valid value
+func truncatable(m manifest) bool {
+	return m.Codec == "" && len(m.Nonce) == 0
+}
+
+// truncate returns the manifest of the first n chunks of the value described
+// by m, whose stored bytes have the given hash
+func truncate(m manifest, n uint64, hash []byte) manifest {
+	res := m
+	res.Chunks, res.Length = n, 0
+	for i := uint64(0); i < n; i++ {
+		res.Length += m.chunkLen(i)
+	}
+	if len(m.ChunkHashes) > 0 {
+		res.ChunkHashes = m.ChunkHashes[:n]
+	}
+	if len(m.ChunkGenerations) > 0 {
+		res.ChunkGenerations = m.ChunkGenerations[:n]
+	}
+	if len(m.Hash) > 0 {
+		res.Hash = hash
+	}
+
+	return res
+}
+
+func detail(where string, err error) string {
+	reason := err.(*CorruptionError).Reason
+	if where == "" {
+		return reason
+	}
+	return where + ": " + reason
+}
@@ -0,0 +1,239 @@
+package storewrapper_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/cosmos/cosmos-sdk/store"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/stretchr/testify/require"
+)
+
+// nsRawKey returns the namespace layout key of a record of a key without zero
+// bytes
+func nsRawKey(ns, key string, tag byte, n uint64) []byte {
+	raw := append([]byte(ns+key), 0x00, 0x01, tag)
+	if tag == 0x00 {
+		return raw
+	}
+	raw = append(raw, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(raw[len(raw)-8:], n)
+	return raw
+}
+
+func nsChunkKey(ns, key string, generation uint32, i uint32) []byte {
+	return nsRawKey(ns, key, 0x01, uint64(generation)<<32|uint64(i))
+}
+
+func kinds(report *storewrapper.CheckReport) []storewrapper.AnomalyKind {
+	var res []storewrapper.AnomalyKind
+	for _, a := range report.Anomalies {
+		res = append(res, a.Kind)
+	}
+	return res
+}
+
+func TestKVStoreCheck(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.WithNamespace([]byte("ns/")).ChunkSize = 100
+
+	_, err = stw.SetW([]byte("Ezekiel"), []byte(sampleLarge))
+	require.Nil(t, err)
+	_, err = stw.SetW([]byte("Jules"), []byte(sampleSmall))
+	require.Nil(t, err)
+	_, err = stw.WithVersions(0).SetW([]byte("Jules"), []byte(sampleLarge[:250]))
+	require.Nil(t, err)
+	_, err = stw.WithDedup(true).SetW([]byte("Vincent"), []byte(sampleLarge[:300]))
+	require.Nil(t, err)
+	_, err = stw.SetW([]byte("Winston"), []byte(sampleLarge[:300]))
+	require.Nil(t, err)
+
+	report, err := stw.CheckW(false)
+	require.Nil(t, err)
+	require.True(t, report.OK(), report.String())
+	require.Equal(t, 4, report.Values)
+	require.Equal(t, 1, report.Versions)
+	require.Equal(t, (len(sampleLarge)+99)/100+2+3+3+3, report.Chunks)
+
+	// a chunk goes missing and another one appears
+	stw.Delete(nsChunkKey("ns/", "Ezekiel", 1, 3))
+	stw.Set(nsChunkKey("ns/", "Ezekiel", 7, 0), []byte("orphan"))
+	stw.Set(nsRawKey("ns/", "Orphan", 0x02, 1), []byte("orphan"))
+
+	report, err = stw.CheckW(false)
+	require.Nil(t, err)
+	require.Equal(t, []storewrapper.AnomalyKind{
+		storewrapper.AnomalyMissingChunk,
+		storewrapper.AnomalyOrphanedChunk,
+		storewrapper.AnomalyOrphanedVersion,
+	}, kinds(report))
+	require.Equal(t, 3, report.Anomalies[0].Chunk)
+	require.Equal(t, 0, report.Repaired)
+
+	report, err = stw.CheckW(true)
+	require.Nil(t, err)
+	require.Len(t, report.Anomalies, 3)
+	// the manifest, the chunks after the missing one and the orphans
+	require.Equal(t, 1+(len(sampleLarge)+99)/100-4+2, report.Repaired)
+
+	report, err = stw.CheckW(false)
+	require.Nil(t, err)
+	require.True(t, report.OK(), report.String())
+
+	// the broken value is truncated
+	res, err := stw.GetW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge[:300], string(res))
+
+	res, err = stw.GetVersionW([]byte("Jules"), 1)
+	require.Nil(t, err)
+	require.Equal(t, sampleSmall, string(res))
+	res, err = stw.GetW([]byte("Winston"))
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge[:300], string(res))
+}
+
+func TestKVStoreCheckDedup(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.WithNamespace([]byte("ns/")).WithDedup(true).ChunkSize = 100
+
+	_, err = stw.SetW([]byte("Vincent"), []byte(sampleLarge[:300]))
+	require.Nil(t, err)
+	_, err = stw.SetW([]byte("Winston"), []byte(sampleLarge[:200]))
+	require.Nil(t, err)
+
+	// Winston's manifest is lost, so the chunks it shares with Vincent have
+	// too many references
+	raw := nsRawKey("ns/", "Winston", 0x00, 0)
+	stw.Set(raw, []byte("garbage"))
+
+	report, err := stw.CheckW(true)
+	require.Nil(t, err)
+	require.Equal(t, []storewrapper.AnomalyKind{
+		storewrapper.AnomalyBadManifest,
+		storewrapper.AnomalyRefCount,
+		storewrapper.AnomalyRefCount,
+	}, kinds(report))
+
+	report, err = stw.CheckW(false)
+	require.Nil(t, err)
+	require.True(t, report.OK(), report.String())
+
+	_, err = stw.DeleteW([]byte("Vincent"))
+	require.Nil(t, err)
+	require.Equal(t, 0, countRaw(stw))
+}
+
+func TestKVStoreCheckLegacyLayout(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	_, err = stw.SetW([]byte("Ezekiel"), []byte(sampleLarge))
+	require.Nil(t, err)
+	// records of other keys can't be told from foreign data
+	stw.Set([]byte("foreign"), []byte("data"))
+	stw.Set(rawChunkKey([]byte("legacy"), 0, 0), []byte(sampleSmall))
+	stw.Set(rawChunkKey([]byte("Ezekiel"), 0, 0), []byte("orphan"))
+
+	report, err := stw.CheckW(false)
+	require.Nil(t, err)
+	require.Equal(t, []storewrapper.AnomalyKind{storewrapper.AnomalyOrphanedChunk}, kinds(report))
+
+	// but they can still collide with chunk keys, so nothing is repaired
+	_, err = stw.CheckW(true)
+	require.Equal(t, storewrapper.ErrRepairNoNamespace, err)
+	require.True(t, stw.Has([]byte("foreign")))
+	require.True(t, stw.Has(rawChunkKey([]byte("legacy"), 0, 0)))
+	require.True(t, stw.Has(rawChunkKey([]byte("Ezekiel"), 0, 0)))
+}
+
+func TestOpenLevelDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storewrapper-check")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := sdk.NewLevelDB("application", dir)
+	require.Nil(t, err)
+	key := sdk.NewKVStoreKey("main")
+	ms := store.NewCommitMultiStore(db)
+	ms.MountStoreWithDB(key, sdk.StoreTypeIAVL, nil)
+	require.Nil(t, ms.LoadLatestVersion())
+
+	_, err = storewrapper.NewKVStore(ms.GetKVStore(key), 100).WithNamespace([]byte("ns/")).
+		SetW([]byte("Ezekiel"), []byte(sampleLarge))
+	require.Nil(t, err)
+	ms.Commit()
+	db.Close()
+
+	_, _, err = storewrapper.OpenLevelDB(dir, "missing", "main")
+	require.NotNil(t, err)
+
+	stw, closeDB, err := storewrapper.OpenLevelDB(dir, "application", "main")
+	require.Nil(t, err)
+	defer closeDB()
+	stw.WithNamespace([]byte("ns/"))
+
+	report, err := stw.CheckW(false)
+	require.Nil(t, err)
+	require.True(t, report.OK(), report.String())
+	require.Equal(t, 1, report.Values)
+
+	res, err := stw.GetW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+	_, err = stw.SetW([]byte("Ezekiel"), []byte(sampleSmall))
+	require.Equal(t, storewrapper.ErrReadOnly, err)
+}
+
+func TestOpenLevelDBWritable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storewrapper-check")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := sdk.NewLevelDB("application", dir)
+	require.Nil(t, err)
+	mainKey, otherKey := sdk.NewKVStoreKey("main"), sdk.NewKVStoreKey("other")
+	ms := store.NewCommitMultiStore(db)
+	ms.MountStoreWithDB(mainKey, sdk.StoreTypeIAVL, nil)
+	ms.MountStoreWithDB(otherKey, sdk.StoreTypeIAVL, nil)
+	require.Nil(t, ms.LoadLatestVersion())
+
+	_, err = storewrapper.NewKVStore(ms.GetKVStore(mainKey), 100).WithNamespace([]byte("ns/")).
+		SetW([]byte("Ezekiel"), []byte(sampleLarge))
+	require.Nil(t, err)
+	ms.GetKVStore(mainKey).Set(nsChunkKey("ns/", "Ezekiel", 7, 0), []byte("orphan"))
+	ms.GetKVStore(otherKey).Set([]byte("Jules"), []byte(sampleSmall))
+	ms.Commit()
+	db.Close()
+
+	stw, commit, closeDB, err := storewrapper.OpenLevelDBWritable(dir, "application", "main")
+	require.Nil(t, err)
+	stw.WithNamespace([]byte("ns/"))
+
+	report, err := stw.CheckW(true)
+	require.Nil(t, err)
+	require.Equal(t, 1, report.Repaired)
+	require.Equal(t, int64(2), commit().Version)
+	closeDB()
+
+	// the repair is saved and the other store is kept
+	db, err = sdk.NewLevelDB("application", dir)
+	require.Nil(t, err)
+	defer db.Close()
+	ms = store.NewCommitMultiStore(db)
+	ms.MountStoreWithDB(mainKey, sdk.StoreTypeIAVL, nil)
+	ms.MountStoreWithDB(otherKey, sdk.StoreTypeIAVL, nil)
+	require.Nil(t, ms.LoadLatestVersion())
+
+	report, err = storewrapper.NewKVStore(ms.GetKVStore(mainKey), 0).WithNamespace([]byte("ns/")).CheckW(false)
+	require.Nil(t, err)
+	require.True(t, report.OK(), report.String())
+	require.Equal(t, []byte(sampleSmall), ms.GetKVStore(otherKey).Get([]byte("Jules")))
+	require.Equal(t, int64(2), ms.GetCommitKVStore(otherKey).LastCommitID().Version)
+}
@@ -0,0 +1,84 @@
+// Command chunkcheck checks the values stored by storewrapper in a store of
+// a stopped node:
+//
+//	chunkcheck -data ~/.appd/data -store main -namespace values/
+//
+// With -repair the anomalies are repaired and saved as a new version of the
+// application state. The node can't continue from it, so repair a copy of
+// the data directory, e.g. to export the state for a new genesis.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/cosmos/cosmos-sdk/store/types"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// run checks the store and returns the exit code: 1 if there are anomalies,
+// 2 on errors
+func run() int {
+	data := flag.String("data", "", "data directory of the node")
+	db := flag.String("db", "application", "name of the application database")
+	storeName := flag.String("store", "", "name of the store holding the values")
+	namespace := flag.String("namespace", "", "namespace of the values, none if empty")
+	isHex := flag.Bool("hex", false, "namespace is hex encoded")
+	repair := flag.Bool("repair", false, "repair the anomalies and commit a new version; the node can't continue from it")
+	flag.Parse()
+
+	if *data == "" || *storeName == "" || *repair && *namespace == "" {
+		flag.Usage()
+		return 2
+	}
+
+	var (
+		stw     *storewrapper.KVStore
+		commit  func() types.CommitID
+		closeDB func()
+		err     error
+	)
+	if *repair {
+		stw, commit, closeDB, err = storewrapper.OpenLevelDBWritable(*data, *db, *storeName)
+	} else {
+		stw, closeDB, err = storewrapper.OpenLevelDB(*data, *db, *storeName)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer closeDB()
+
+	if *namespace != "" {
+		prefix := []byte(*namespace)
+		if *isHex {
+			if prefix, err = hex.DecodeString(*namespace); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+		}
+		stw.WithNamespace(prefix)
+	}
+
+	report, err := stw.CheckW(*repair)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	fmt.Println(report)
+	if *repair && report.Repaired > 0 {
+		fmt.Printf("committed version %d\n", commit().Version)
+	}
+	if !report.OK() {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,143 @@
+package storewrapper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// Compressor compresses values before they are chunked. The name is stored in
+// the manifest so GetW can pick the same compressor when reading the value.
+//
+// Implementations must be deterministic: every node has to produce identical
+// bytes for identical input, otherwise validators end up with different state.
+// A zstd compressor can be registered by the application as long as its
+// encoder settings are fixed.
+type Compressor interface {
+	Name() string
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+var (
+	// GzipCompressor uses compress/gzip with the default level and an empty
+	// header. Its output depends on compress/flate, so all nodes should be
+	// built with the same Go release.
+	GzipCompressor Compressor = gzipCompressor{}
+
+	// SnappyCompressor uses the snappy block format which is stable for a
+	// given version of github.com/golang/snappy.
+	SnappyCompressor Compressor = snappyCompressor{}
+
+	compressorsMtx sync.RWMutex
+	compressors    = map[string]Compressor{}
+)
+
+func init() {
+	RegisterCompressor(GzipCompressor)
+	RegisterCompressor(SnappyCompressor)
+}
+
+// RegisterCompressor makes a compressor available for reading values written
+// with it. It panics if a compressor with the same name is already registered.
+func RegisterCompressor(c Compressor) {
+	compressorsMtx.Lock()
+	defer compressorsMtx.Unlock()
+
+	if _, ok := compressors[c.Name()]; ok {
+		panic(fmt.Sprintf("compressor %q is already registered", c.Name()))
+	}
+	compressors[c.Name()] = c
+}
+
+// GetCompressor returns a registered compressor by name
+func GetCompressor(name string) (Compressor, bool) {
+	compressorsMtx.RLock()
+	defer compressorsMtx.RUnlock()
+
+	c, ok := compressors[name]
+	return c, ok
+}
+
+// WithCompressor returns the wrapper compressing subsequent writes with c;
+// nil disables compression. Values are always decompressed with the
+// compressor recorded in their manifest.
+func (k *KVStore) WithCompressor(c Compressor) *KVStore {
+	k.Compressor = c
+	return k
+}
+
+// compress returns the bytes to be stored and the compressor name
+func (k *KVStore) compress(value []byte) ([]byte, string, error) {
+	if k.Compressor == nil {
+		return value, "", nil
+	}
+
+	res, err := k.Compressor.Compress(value)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return res, k.Compressor.Name(), nil
+}
+
+// decompress restores a value read from the store
+func (k *KVStore) decompress(m manifest, stored []byte) ([]byte, error) {
+	if m.Codec == "" {
+		return stored, nil
+	}
+
+	c, ok := GetCompressor(m.Codec)
+	if k.Compressor != nil && k.Compressor.Name() == m.Codec {
+		c, ok = k.Compressor, true
+	}
+	if !ok {
+		return nil, ErrUnknownCompressor(m.Codec)
+	}
+
+	return c.Decompress(stored)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string { return "gzip" }
+
+func (gzipCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string { return "snappy" }
+
+func (snappyCompressor) Compress(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCompressor) Decompress(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
@@ -0,0 +1,37 @@
+package storewrapper_test
+
+import (
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStoreCompression(t *testing.T) {
+	for _, c := range []storewrapper.Compressor{storewrapper.GzipCompressor, storewrapper.SnappyCompressor} {
+		t.Run(c.Name(), func(t *testing.T) {
+			stw, err := makeTestStore()
+			require.Nil(t, err)
+			stw.ChunkSize = 100
+
+			key := []byte("Ezekiel")
+			plainParts, err := stw.SetW(key, []byte(sampleLarge))
+			require.Nil(t, err)
+
+			parts, err := stw.WithCompressor(c).SetW(key, []byte(sampleLarge))
+			require.Nil(t, err)
+			require.True(t, parts < plainParts)
+
+			// output is deterministic
+			first := stw.Get(rawChunkKey(key, 2, 0))
+			_, err = stw.SetW(key, []byte(sampleLarge))
+			require.Nil(t, err)
+			require.Equal(t, first, stw.Get(rawChunkKey(key, 3, 0)))
+
+			// codec is taken from the manifest, not from the wrapper
+			res, err := stw.WithCompressor(nil).GetW(key)
+			require.Nil(t, err)
+			require.Equal(t, sampleLarge, string(res))
+		})
+	}
+}
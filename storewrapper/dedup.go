@@ -0,0 +1,64 @@
+package storewrapper
+
+import (
+	"encoding/binary"
+)
+
+// WithDedup returns the wrapper storing chunks of subsequent writes under
+// their content hash, so identical chunks of any values in the namespace are
+// stored once. Every chunk has a reference count and is deleted when no
+// value refers to it anymore. Deduplication requires a namespace.
+//
+// If a write fails before the value is switched, the references it added
+// are never released and the chunks stay in the store.
+func (k *KVStore) WithDedup(enabled bool) *KVStore {
+	k.Dedup = enabled
+	return k
+}
+
+// writeChunk stores chunk i of the value described by m
+func (k *KVStore) writeChunk(keySrc []byte, m manifest, i uint64, chunk []byte) {
+	if !m.Dedup {
+		k.Set(k.chunkKey(keySrc, m, i), chunk)
+		return
+	}
+
+	hash := m.ChunkHashes[i]
+	refs := k.refs(hash)
+	if refs == 0 {
+		k.Set(k.keys().blobKey(hash), chunk)
+	}
+	k.setRefs(hash, refs+1)
+}
+
+// releaseChunk drops the reference of a value to a deduplicated chunk of
+// size bytes and deletes the chunk if it was the last one
+func (k *KVStore) releaseChunk(hash []byte, size uint64) {
+	refs := k.refs(hash)
+	if refs > 1 {
+		k.setRefs(hash, refs-1)
+		return
+	}
+
+	k.Delete(k.keys().refKey(hash))
+	k.Delete(k.keys().blobKey(hash))
+	k.obs.deleted(size)
+}
+
+func (k *KVStore) refs(hash []byte) uint64 {
+	bz := k.Get(k.keys().refKey(hash))
+	if len(bz) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(bz)
+}
+
+func (k *KVStore) setRefs(hash []byte, refs uint64) {
+	k.Set(k.keys().refKey(hash), refsBytes(refs))
+}
+
+func refsBytes(refs uint64) []byte {
+	bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(bz, refs)
+	return bz
+}
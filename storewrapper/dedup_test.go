@@ -0,0 +1,86 @@
+package storewrapper_test
+
+import (
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/stretchr/testify/require"
+)
+
+func countRaw(stw *storewrapper.KVStore) int {
+	it := stw.Iterator(nil, nil)
+	defer it.Close()
+
+	n := 0
+	for ; it.Valid(); it.Next() {
+		n++
+	}
+	return n
+}
+
+func TestKVStoreDedup(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	_, err = stw.WithDedup(true).SetW([]byte("Ezekiel"), []byte(sampleLarge))
+	require.Equal(t, storewrapper.ErrNoNamespace, err)
+
+	stw.WithNamespace([]byte("ns/"))
+	parts, err := stw.SetW([]byte("Ezekiel"), []byte(sampleLarge))
+	require.Nil(t, err)
+	// manifest, chunks and reference counts
+	single := countRaw(stw)
+	require.Equal(t, 1+2*parts, single)
+
+	_, err = stw.SetW([]byte("Ezekiel 25:17"), []byte(sampleLarge))
+	require.Nil(t, err)
+	require.Equal(t, single+1, countRaw(stw))
+
+	w := stw.NewValueWriter([]byte("Jules"))
+	_, err = w.Write([]byte(sampleLarge))
+	require.Nil(t, err)
+	require.Nil(t, w.Close())
+	require.Equal(t, single+2, countRaw(stw))
+
+	// the value is kept while other keys refer to its chunks
+	_, err = stw.DeleteW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	_, err = stw.DeleteW([]byte("Jules"))
+	require.Nil(t, err)
+	res, err := stw.GetW([]byte("Ezekiel 25:17"))
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+
+	// overwriting releases the chunks of the old value
+	_, err = stw.SetW([]byte("Ezekiel 25:17"), []byte(sampleSmall))
+	require.Nil(t, err)
+	res, err = stw.GetW([]byte("Ezekiel 25:17"))
+	require.Nil(t, err)
+	require.Equal(t, sampleSmall, string(res))
+
+	_, err = stw.DeleteW([]byte("Ezekiel 25:17"))
+	require.Nil(t, err)
+	require.Equal(t, 0, countRaw(stw))
+}
+
+func TestKVStoreDedupVersions(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.WithNamespace([]byte("ns/")).WithDedup(true).WithVersions(0).ChunkSize = 100
+
+	_, err = stw.SetW([]byte("Ezekiel"), []byte(sampleLarge))
+	require.Nil(t, err)
+	_, err = stw.SetW([]byte("Ezekiel"), []byte(sampleSmall))
+	require.Nil(t, err)
+
+	res, err := stw.GetVersionW([]byte("Ezekiel"), 1)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+
+	_, err = stw.PruneVersionsW([]byte("Ezekiel"), 100)
+	require.Nil(t, err)
+	_, err = stw.DeleteW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	require.Equal(t, 0, countRaw(stw))
+}
@@ -0,0 +1,72 @@
+package storewrapper
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+)
+
+// NewAESGCM returns an AES-GCM AEAD for WithCipher; the key must be 16, 24 or
+// 32 bytes long
+func NewAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// WithCipher returns the wrapper encrypting subsequent writes with aead after
+// compression; nil disables encryption. The logical key is authenticated
+// along with the value, so a value moved to another key fails to decrypt.
+//
+// Nonces are random and stored in the manifest, so encrypted writes are not
+// deterministic: use it for local stores only, never for consensus state.
+func (k *KVStore) WithCipher(aead cipher.AEAD) *KVStore {
+	k.Cipher = aead
+	return k
+}
+
+// encrypt returns the bytes to be stored and the nonce used
+func (k *KVStore) encrypt(keySrc, value []byte) ([]byte, []byte, error) {
+	if k.Cipher == nil {
+		return value, nil, nil
+	}
+
+	nonce := make([]byte, k.Cipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	return k.Cipher.Seal(nil, nonce, value, keySrc), nonce, nil
+}
+
+// decrypt authenticates and decrypts a value read from the store
+func (k *KVStore) decrypt(keySrc []byte, m manifest, stored []byte) ([]byte, error) {
+	if len(m.Nonce) == 0 {
+		return stored, nil
+	}
+	if k.Cipher == nil {
+		return nil, ErrNoCipher
+	}
+	if len(m.Nonce) != k.Cipher.NonceSize() {
+		return nil, ErrDecryption
+	}
+
+	res, err := k.Cipher.Open(nil, m.Nonce, stored, keySrc)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+
+	return res, nil
+}
+
+// storedSize returns the size of a value after encryption, ignoring
+// compression
+func (k *KVStore) storedSize(size int) int {
+	if k.Cipher == nil || size == 0 {
+		return size
+	}
+	return size + k.Cipher.Overhead()
+}
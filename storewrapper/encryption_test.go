@@ -0,0 +1,46 @@
+package storewrapper_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStoreEncryption(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	aead, err := storewrapper.NewAESGCM(bytes.Repeat([]byte{1}, 32))
+	require.Nil(t, err)
+	wrongAead, err := storewrapper.NewAESGCM(bytes.Repeat([]byte{2}, 32))
+	require.Nil(t, err)
+
+	key := []byte("Ezekiel")
+	_, err = stw.WithCipher(aead).SetW(key, []byte(sampleLarge))
+	require.Nil(t, err)
+	require.Equal(t, stw.EstimateSetW(len(sampleLarge), 0), stw.LastOps())
+	require.False(t, bytes.Contains(stw.Get(rawChunkKey(key, 1, 0)), []byte(sampleLarge[:50])))
+
+	res, err := stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+
+	_, err = stw.WithCipher(wrongAead).GetW(key)
+	require.Equal(t, storewrapper.ErrDecryption, err)
+
+	_, err = stw.WithCipher(nil).GetW(key)
+	require.Equal(t, storewrapper.ErrNoCipher, err)
+
+	_, err = stw.NewValueReader(key)
+	require.Equal(t, storewrapper.ErrEncryptedStream, err)
+
+	// encryption is applied after compression
+	_, err = stw.WithCipher(aead).WithCompressor(storewrapper.SnappyCompressor).SetW(key, []byte(sampleLarge))
+	require.Nil(t, err)
+	res, err = stw.WithCompressor(nil).GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+}
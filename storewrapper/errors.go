@@ -0,0 +1,114 @@
+package storewrapper
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotFound          = errors.New("value not found")
+	ErrCompressedStream  = errors.New("compressed values can't be streamed")
+	ErrEncryptedStream   = errors.New("encrypted values can't be streamed")
+	ErrNoCipher          = errors.New("value is encrypted, but the store has no cipher")
+	ErrDecryption        = errors.New("value can't be decrypted: wrong key or tampered ciphertext")
+	ErrWriterClosed      = errors.New("value writer is closed")
+	ErrWriteConflict     = errors.New("value was replaced while it was streamed")
+	ErrNoNamespace       = errors.New("store has no namespace")
+	ErrVersionNotFound   = errors.New("version not found")
+	ErrPruneBudget       = errors.New("prune budget is too small for the oldest version")
+	ErrPartialUpdate     = errors.New("compressed or encrypted values can't be updated in place")
+	ErrInvalidOffset     = errors.New("offset is past the end of the value")
+	ErrInvalidRange      = errors.New("range offset and length must not be negative")
+	ErrReadOnly          = errors.New("store is read-only")
+	ErrRepairNoNamespace = errors.New("values without a namespace can't be repaired")
+)
+
+// CorruptionError is returned when a stored value doesn't match its manifest.
+// Chunk is the index of the bad chunk or -1 if the value as a whole is bad.
+type CorruptionError struct {
+	Key    []byte
+	Chunk  int
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	if e.Chunk < 0 {
+		return fmt.Sprintf("value %X is corrupted: %s", e.Key, e.Reason)
+	}
+	return fmt.Sprintf("chunk %d of value %X is corrupted: %s", e.Chunk, e.Key, e.Reason)
+}
+
+// IsCorruption reports whether err is a *CorruptionError
+func IsCorruption(err error) bool {
+	_, ok := err.(*CorruptionError)
+	return ok
+}
+
+// DecodeError is returned by TypedStore when a stored value can't be decoded
+type DecodeError struct {
+	Key []byte
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("can't decode value %X: %v", e.Key, e.Err)
+}
+
+// BatchError is returned by batch operations with the error of the first key
+// that failed; Index is its position in the batch
+type BatchError struct {
+	Key   []byte
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch operation %d on value %X failed: %v", e.Index, e.Key, e.Err)
+}
+
+// DuplicateRecordError is returned when imported records have the same key
+// at positions First and Index
+type DuplicateRecordError struct {
+	Key   []byte
+	First int
+	Index int
+}
+
+func (e *DuplicateRecordError) Error() string {
+	return fmt.Sprintf("records %d and %d have the same key %X", e.First, e.Index, e.Key)
+}
+
+// ValueTooLargeError is returned when a value exceeds KVStore.MaxValueSize
+type ValueTooLargeError struct {
+	Size int
+	Max  int
+}
+
+func (e *ValueTooLargeError) Error() string {
+	return fmt.Sprintf("value of %d bytes exceeds the limit of %d bytes", e.Size, e.Max)
+}
+
+// ChunkSizeError is returned when KVStore.ChunkSize is out of [Min, Max]
+type ChunkSizeError struct {
+	Size int
+	Min  int
+	Max  int
+}
+
+func (e *ChunkSizeError) Error() string {
+	return fmt.Sprintf("chunk size %d is out of range [%d, %d]", e.Size, e.Min, e.Max)
+}
+
+// ErrUnknownCompressor is returned when a value was written with a compressor
+// that isn't registered
+func ErrUnknownCompressor(name string) error {
+	return fmt.Errorf("unknown compressor %q, use RegisterCompressor to make it available", name)
+}
+
+func errCorrupted(key []byte, chunk int, format string, args ...interface{}) error {
+	return &CorruptionError{
+		Key:    key,
+		Chunk:  chunk,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,273 @@
+package storewrapper
+
+import (
+	"github.com/cosmos/cosmos-sdk/store/types"
+)
+
+// Ops counts operations performed on the underlying store
+type Ops struct {
+	Has          int
+	Get          int
+	Set          int
+	Delete       int
+	BytesRead    int
+	BytesWritten int
+}
+
+// Gas returns the gas the operations consume with the given config, e.g.
+// types.KVGasConfig() for stores obtained from sdk.Context
+func (o Ops) Gas(cfg types.GasConfig) uint64 {
+	return uint64(o.Has)*cfg.HasCost +
+		uint64(o.Get)*cfg.ReadCostFlat + uint64(o.BytesRead)*cfg.ReadCostPerByte +
+		uint64(o.Set)*cfg.WriteCostFlat + uint64(o.BytesWritten)*cfg.WriteCostPerByte +
+		uint64(o.Delete)*cfg.DeleteCost
+}
+
+// Add returns the sum of two op counts
+func (o Ops) Add(other Ops) Ops {
+	return Ops{
+		Has:          o.Has + other.Has,
+		Get:          o.Get + other.Get,
+		Set:          o.Set + other.Set,
+		Delete:       o.Delete + other.Delete,
+		BytesRead:    o.BytesRead + other.BytesRead,
+		BytesWritten: o.BytesWritten + other.BytesWritten,
+	}
+}
+
+// WithMaxValueSize returns the wrapper rejecting values larger than size
+// bytes; 0 means no limit
+func (k *KVStore) WithMaxValueSize(size int) *KVStore {
+	k.MaxValueSize = size
+	return k
+}
+
+// ValidateSize returns a *ValueTooLargeError if a value of the given size
+// can't be written. It doesn't touch the store, so handlers can reject
+// oversized payloads before consuming gas.
+func (k *KVStore) ValidateSize(size int) error {
+	if k.MaxValueSize > 0 && size > k.MaxValueSize {
+		return &ValueTooLargeError{Size: size, Max: k.MaxValueSize}
+	}
+
+	return nil
+}
+
+// LastOps returns the operations performed on the underlying store by the
+// last GetW, HasW, SetW or DeleteW call. Operations of calls made by another
+// call, e.g. SetW by MigrateLegacyW, are included in the outer call. It is
+// only tracked for wrappers created with NewKVStore.
+func (k *KVStore) LastOps() Ops {
+	return k.lastOps
+}
+
+// Estimates for deduplicated values assume that every written chunk is new
+// and every deleted chunk has no other references.
+
+// EstimateGetW returns the operations GetW performs to read a value of size
+// bytes written by this wrapper
+func (k *KVStore) EstimateGetW(size int) Ops {
+	size = k.storedSize(size)
+	chunks := k.chunksFor(size)
+
+	return Ops{
+		Get:       1 + chunks,
+		BytesRead: k.manifestSizeFor(size) + size,
+	}
+}
+
+// EstimateSetW returns the operations SetW performs to write a value of size
+// bytes replacing a value of oldSize bytes written by this wrapper; oldSize 0
+// means there is no value yet. Compression isn't taken into account, so the
+// estimate is an upper bound for compressible values. With versions a value
+// without a manifest is read once more to be kept as a version.
+func (k *KVStore) EstimateSetW(size, oldSize int) Ops {
+	size, oldSize = k.storedSize(size), k.storedSize(oldSize)
+
+	ops := Ops{
+		Get:          1,
+		Set:          k.chunksFor(size) + 1,
+		Delete:       k.chunksFor(oldSize),
+		BytesWritten: size + k.manifestSizeFor(size),
+	}
+	if k.Versioned {
+		// the old value is kept as a version record instead
+		ops.Delete = 0
+		if oldSize > 0 {
+			ops.Set++
+			ops.BytesWritten += k.manifestSizeFor(oldSize)
+		}
+	}
+
+	if oldSize == 0 && k.keys().hasLegacy() {
+		// no manifest, so SetW looks for a value without one
+		ops.Has++
+	} else {
+		ops.BytesRead += k.manifestSizeFor(oldSize)
+	}
+	if k.Dedup {
+		ops = ops.Add(k.dedupWrites(size))
+		if !k.Versioned {
+			ops = ops.Add(k.dedupDeletes(oldSize))
+		}
+	}
+
+	return ops
+}
+
+// EstimateDeleteW returns the operations DeleteW performs to delete a value
+// of size bytes written by this wrapper
+func (k *KVStore) EstimateDeleteW(size int) Ops {
+	size = k.storedSize(size)
+
+	ops := Ops{
+		Get:       1,
+		Delete:    k.chunksFor(size) + 1,
+		BytesRead: k.manifestSizeFor(size),
+	}
+	if k.Dedup {
+		ops = ops.Add(k.dedupDeletes(size))
+	}
+
+	return ops
+}
+
+// dedupWrites returns the reference count operations of writing new
+// deduplicated chunks
+func (k *KVStore) dedupWrites(size int) Ops {
+	chunks := k.chunksFor(size)
+	return Ops{Get: chunks, Set: chunks, BytesWritten: 8 * chunks}
+}
+
+// dedupDeletes returns the reference count operations of deleting
+// deduplicated chunks with no other references
+func (k *KVStore) dedupDeletes(size int) Ops {
+	chunks := k.chunksFor(size)
+	return Ops{Get: chunks, Delete: chunks, BytesRead: 8 * chunks}
+}
+
+func (k *KVStore) chunksFor(size int) int {
+	chunkSize := k.chunkSize()
+	return (size + chunkSize - 1) / chunkSize
+}
+
+// manifestSizeFor returns the encoded manifest size of a value of size
+// stored bytes
+func (k *KVStore) manifestSizeFor(size int) int {
+	chunks := k.chunksFor(size)
+
+	m := manifest{
+		Version:    ManifestVersion,
+		Length:     uint64(size),
+		Chunks:     uint64(chunks),
+		ChunkSize:  uint64(k.chunkSize()),
+		Hash:       make([]byte, hashSize),
+		Generation: 1,
+	}
+	if k.ChunkHashes || k.Dedup {
+		m.ChunkHashes = make([][]byte, chunks)
+		for i := range m.ChunkHashes {
+			m.ChunkHashes[i] = make([]byte, hashSize)
+		}
+	}
+	if k.Compressor != nil {
+		m.Codec = k.Compressor.Name()
+	}
+	if k.Cipher != nil {
+		m.Nonce = make([]byte, k.Cipher.NonceSize())
+	}
+	if k.Versioned {
+		m.ValueVersion, m.OldestVersion = 1, 1
+	}
+	m.Dedup = k.Dedup
+
+	return len(m.bytes())
+}
+
+// trackOps starts counting store operations; the returned func saves the
+// operations performed since as LastOps. Calls can be nested: counting
+// starts from zero only in the outermost call.
+func (k *KVStore) trackOps() func() {
+	c, ok := k.KVStore.(*opCounter)
+	if !ok {
+		return func() {}
+	}
+
+	if c.depth == 0 {
+		c.ops, c.reads = Ops{}, nil
+	}
+	c.depth++
+	start := c.ops
+
+	return func() {
+		c.depth--
+		k.lastOps = c.ops.sub(start)
+	}
+}
+
+// sub returns the difference of two op counts
+func (o Ops) sub(other Ops) Ops {
+	return Ops{
+		Has:          o.Has - other.Has,
+		Get:          o.Get - other.Get,
+		Set:          o.Set - other.Set,
+		Delete:       o.Delete - other.Delete,
+		BytesRead:    o.BytesRead - other.BytesRead,
+		BytesWritten: o.BytesWritten - other.BytesWritten,
+	}
+}
+
+// traceReads starts recording the sizes of values read from the store; the
+// returned func stops and returns them, -1 for every Has
+func (k *KVStore) traceReads() func() []int {
+	c, ok := k.KVStore.(*opCounter)
+	if !ok {
+		return func() []int { return nil }
+	}
+
+	c.reads = []int{}
+	return func() []int {
+		reads := c.reads
+		c.reads = nil
+		return reads
+	}
+}
+
+// opCounter counts operations passed to the underlying store; depth is the
+// number of wrapper calls in progress and reads, unless nil, the trace of
+// reads started by traceReads
+type opCounter struct {
+	types.KVStore
+	ops   Ops
+	depth int
+	reads []int
+}
+
+func (c *opCounter) Get(key []byte) []byte {
+	value := c.KVStore.Get(key)
+	c.ops.Get++
+	c.ops.BytesRead += len(value)
+	if c.reads != nil {
+		c.reads = append(c.reads, len(value))
+	}
+	return value
+}
+
+func (c *opCounter) Has(key []byte) bool {
+	c.ops.Has++
+	if c.reads != nil {
+		c.reads = append(c.reads, -1)
+	}
+	return c.KVStore.Has(key)
+}
+
+func (c *opCounter) Set(key, value []byte) {
+	c.ops.Set++
+	c.ops.BytesWritten += len(value)
+	c.KVStore.Set(key, value)
+}
+
+func (c *opCounter) Delete(key []byte) {
+	c.ops.Delete++
+	c.KVStore.Delete(key)
+}
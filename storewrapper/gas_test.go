@@ -0,0 +1,72 @@
+package storewrapper_test
+
+import (
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStoreOpsEstimate(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.WithChunkHashes(true).ChunkSize = 100
+
+	key := []byte("Ezekiel")
+	_, err = stw.SetW(key, []byte(sampleLarge))
+	require.Nil(t, err)
+	require.Equal(t, stw.EstimateSetW(len(sampleLarge), 0), stw.LastOps())
+
+	_, err = stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, stw.EstimateGetW(len(sampleLarge)), stw.LastOps())
+
+	_, err = stw.SetW(key, []byte(sampleSmall))
+	require.Nil(t, err)
+	require.Equal(t, stw.EstimateSetW(len(sampleSmall), len(sampleLarge)), stw.LastOps())
+	require.NotZero(t, stw.LastOps().Delete)
+
+	_, err = stw.DeleteW(key)
+	require.Nil(t, err)
+	require.Equal(t, stw.EstimateDeleteW(len(sampleSmall)), stw.LastOps())
+}
+
+func TestKVStoreNestedOps(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	for _, key := range []string{"Jules", "Vincent"} {
+		_, err = stw.SetW([]byte(key), []byte(sampleLarge))
+		require.Nil(t, err)
+	}
+
+	// both moves are counted, not only the last DeleteW
+	moved, err := stw.WithNamespace([]byte("ns/")).MigrateLegacyW([]byte("Jules"), []byte("Vincent"))
+	require.Nil(t, err)
+	require.Equal(t, 2, moved)
+
+	set := stw.EstimateSetW(len(sampleLarge), 0)
+	del := stw.Legacy().EstimateDeleteW(len(sampleLarge))
+	require.Equal(t, 2*set.Set, stw.LastOps().Set)
+	require.Equal(t, 2*set.BytesWritten, stw.LastOps().BytesWritten)
+	require.Equal(t, 2*del.Delete, stw.LastOps().Delete)
+}
+
+func TestKVStoreMaxValueSize(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.WithMaxValueSize(len(sampleSmall))
+
+	key := []byte("Ezekiel")
+	_, err = stw.SetW(key, []byte(sampleSmall))
+	require.Nil(t, err)
+
+	_, err = stw.SetW(key, []byte(sampleLarge))
+	require.IsType(t, &storewrapper.ValueTooLargeError{}, err)
+	require.Equal(t, storewrapper.Ops{}, stw.LastOps())
+
+	res, err := stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, sampleSmall, string(res))
+}
@@ -0,0 +1,121 @@
+package storewrapper
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/cosmos/cosmos-sdk/store/types"
+)
+
+// Record is a logical value as it is kept in genesis. Amino JSON encodes the
+// bytes as base64; HexRecord keeps them readable.
+type Record struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// HexRecord is a Record with hex encoded key and value
+type HexRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ExportW returns the values of all logical keys starting with prefix, ordered
+// by key. The wrapper options don't affect the result, so the records can be
+// imported into a store with another chunk size, compressor or namespace.
+func (k *KVStore) ExportW(prefix []byte) (records []Record, err error) {
+	defer recoverError(&err)
+
+	var end []byte
+	if len(prefix) > 0 {
+		end = types.PrefixEndBytes(prefix)
+	}
+
+	it := k.IteratorW(prefix, end)
+	defer it.Close()
+
+	records = []Record{}
+	for ; it.Valid(); it.Next() {
+		value, err := it.ValueW()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, Record{Key: concat(it.Key()), Value: value})
+	}
+
+	// without a namespace raw keys don't always sort like logical keys
+	sort.SliceStable(records, func(i, j int) bool {
+		return bytes.Compare(records[i].Key, records[j].Key) < 0
+	})
+
+	return records, nil
+}
+
+// ImportW writes records exported by ExportW; return parts written, error.
+// Records must have distinct keys, otherwise a *DuplicateRecordError is
+// returned. Like Batch.WriteW the writes are all-or-nothing.
+func (k *KVStore) ImportW(records []Record) (parts int, err error) {
+	if err = ValidateRecords(records); err != nil {
+		return 0, err
+	}
+
+	b := k.NewBatch()
+	for _, r := range records {
+		b.Set(r.Key, r.Value)
+	}
+
+	written, err := b.WriteW()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, n := range written {
+		parts += n
+	}
+
+	return parts, nil
+}
+
+// ValidateRecords checks that records have distinct keys, e.g. in
+// ValidateGenesis of a module
+func ValidateRecords(records []Record) error {
+	seen := make(map[string]int, len(records))
+	for i, r := range records {
+		if first, ok := seen[string(r.Key)]; ok {
+			return &DuplicateRecordError{Key: r.Key, First: first, Index: i}
+		}
+		seen[string(r.Key)] = i
+	}
+
+	return nil
+}
+
+// HexRecords returns records with hex encoded keys and values
+func HexRecords(records []Record) []HexRecord {
+	res := make([]HexRecord, len(records))
+	for i, r := range records {
+		res[i] = HexRecord{Key: hex.EncodeToString(r.Key), Value: hex.EncodeToString(r.Value)}
+	}
+
+	return res
+}
+
+// FromHexRecords decodes records returned by HexRecords
+func FromHexRecords(records []HexRecord) ([]Record, error) {
+	res := make([]Record, len(records))
+	for i, r := range records {
+		key, err := hex.DecodeString(r.Key)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: invalid key: %v", i, err)
+		}
+		value, err := hex.DecodeString(r.Value)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: invalid value: %v", i, err)
+		}
+		res[i] = Record{Key: key, Value: value}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,88 @@
+package storewrapper_test
+
+import (
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/stretchr/testify/require"
+)
+
+type testGenesis struct {
+	Records []storewrapper.Record    `json:"records"`
+	Hex     []storewrapper.HexRecord `json:"hex"`
+}
+
+func TestKVStoreExportImport(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.WithNamespace([]byte("ns/")).ChunkSize = 100
+
+	for key, value := range map[string]string{
+		"film/Vincent": sampleSmall,
+		"film/Jules":   sampleLarge,
+		"film/Mia":     "",
+		"other":        sampleSmall,
+	} {
+		_, err := stw.SetW([]byte(key), []byte(value))
+		require.Nil(t, err)
+	}
+
+	records, err := stw.ExportW([]byte("film/"))
+	require.Nil(t, err)
+	require.Equal(t, []storewrapper.Record{
+		{Key: []byte("film/Jules"), Value: []byte(sampleLarge)},
+		{Key: []byte("film/Mia"), Value: []byte{}},
+		{Key: []byte("film/Vincent"), Value: []byte(sampleSmall)},
+	}, records)
+
+	// records survive amino JSON in both encodings
+	cdc := codec.New()
+	bz, err := cdc.MarshalJSON(testGenesis{Records: records, Hex: storewrapper.HexRecords(records)})
+	require.Nil(t, err)
+
+	var genesis testGenesis
+	require.Nil(t, cdc.UnmarshalJSON(bz, &genesis))
+	fromHex, err := storewrapper.FromHexRecords(genesis.Hex)
+	require.Nil(t, err)
+	require.Len(t, fromHex, len(records))
+	require.Len(t, genesis.Records, len(records))
+	for i := range records {
+		require.Equal(t, string(records[i].Key), string(genesis.Records[i].Key))
+		require.Equal(t, string(records[i].Value), string(genesis.Records[i].Value))
+		require.Equal(t, string(records[i].Key), string(fromHex[i].Key))
+		require.Equal(t, string(records[i].Value), string(fromHex[i].Value))
+	}
+
+	// import into a store with other options
+	dst, err := makeTestStore()
+	require.Nil(t, err)
+	dst.WithCompressor(storewrapper.GzipCompressor).ChunkSize = 64
+
+	_, err = dst.ImportW(genesis.Records)
+	require.Nil(t, err)
+
+	exported, err := dst.ExportW(nil)
+	require.Nil(t, err)
+	require.Equal(t, records, exported)
+}
+
+func TestKVStoreImportDuplicates(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+
+	_, err = stw.ImportW([]storewrapper.Record{
+		{Key: []byte("Jules"), Value: []byte(sampleSmall)},
+		{Key: []byte("Vincent"), Value: []byte(sampleSmall)},
+		{Key: []byte("Jules"), Value: []byte(sampleLarge)},
+	})
+	require.Equal(t, &storewrapper.DuplicateRecordError{Key: []byte("Jules"), First: 0, Index: 2}, err)
+
+	// nothing was written
+	records, err := stw.ExportW(nil)
+	require.Nil(t, err)
+	require.Empty(t, records)
+
+	_, err = storewrapper.FromHexRecords([]storewrapper.HexRecord{{Key: "zz"}})
+	require.NotNil(t, err)
+}
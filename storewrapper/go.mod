@@ -4,6 +4,8 @@ go 1.12
 
 require (
 	github.com/cosmos/cosmos-sdk v0.28.2-0.20190827131926-5aacf454e1b6
+	github.com/golang/snappy v0.0.1
+	github.com/prometheus/client_golang v0.9.2
 	github.com/stretchr/testify v1.3.0
 	github.com/tendermint/tendermint v0.32.2
 )
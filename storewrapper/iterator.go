@@ -0,0 +1,147 @@
+package storewrapper
+
+import (
+	"bytes"
+
+	"github.com/cosmos/cosmos-sdk/store/types"
+)
+
+var _ types.Iterator = (*ValueIterator)(nil)
+
+// ValueIterator iterates over values stored with SetW, one entry per logical
+// key. Values are loaded lazily when Value or ValueW is called.
+//
+// With a namespace entries come in the order of logical keys. Without one
+// they come in the order of the underlying chunk keys, which matches the
+// order of logical keys unless one key is a prefix of another, and empty keys
+// are skipped when an end bound is set.
+type ValueIterator struct {
+	k       *KVStore
+	raw     types.Iterator
+	start   []byte
+	end     []byte
+	reverse bool
+
+	key    []byte
+	value  []byte
+	err    error
+	loaded bool
+}
+
+// IteratorW returns an iterator over logical keys in [start, end)
+func (k *KVStore) IteratorW(start, end []byte) *ValueIterator {
+	return newValueIterator(k, start, end, false)
+}
+
+// ReverseIteratorW returns an iterator over logical keys in [start, end) in
+// reverse order
+func (k *KVStore) ReverseIteratorW(start, end []byte) *ValueIterator {
+	return newValueIterator(k, start, end, true)
+}
+
+func newValueIterator(k *KVStore, start, end []byte, reverse bool) *ValueIterator {
+	rawStart, rawEnd := k.keys().rawRange(start, end)
+
+	it := &ValueIterator{
+		k:       k,
+		start:   start,
+		end:     end,
+		reverse: reverse,
+	}
+	if reverse {
+		it.raw = k.KVStore.ReverseIterator(rawStart, rawEnd)
+	} else {
+		it.raw = k.KVStore.Iterator(rawStart, rawEnd)
+	}
+	it.seek()
+
+	return it
+}
+
+// Domain returns the logical key range of the iterator
+func (it *ValueIterator) Domain() (start, end []byte) {
+	return it.start, it.end
+}
+
+// Valid returns whether the iterator points to a value
+func (it *ValueIterator) Valid() bool {
+	return it.key != nil
+}
+
+// Next moves to the next logical key
+func (it *ValueIterator) Next() {
+	if !it.Valid() {
+		panic("iterator is invalid")
+	}
+
+	it.raw.Next()
+	it.seek()
+}
+
+// Key returns the logical key
+func (it *ValueIterator) Key() []byte {
+	if !it.Valid() {
+		panic("iterator is invalid")
+	}
+
+	return it.key
+}
+
+// Value returns the reassembled value; it panics if the value can't be read
+func (it *ValueIterator) Value() []byte {
+	value, err := it.ValueW()
+	if err != nil {
+		panic(err)
+	}
+
+	return value
+}
+
+// ValueW returns the reassembled value or an error if it can't be read
+func (it *ValueIterator) ValueW() ([]byte, error) {
+	if !it.Valid() {
+		panic("iterator is invalid")
+	}
+
+	if !it.loaded {
+		it.value, it.err = it.k.GetW(it.key)
+		it.loaded = true
+	}
+
+	return it.value, it.err
+}
+
+// Close releases the underlying iterator
+func (it *ValueIterator) Close() {
+	it.raw.Close()
+}
+
+// seek advances the raw iterator to the next key that starts a logical value
+func (it *ValueIterator) seek() {
+	it.key, it.value, it.err, it.loaded = nil, nil, nil, false
+
+	for ; it.raw.Valid(); it.raw.Next() {
+		if key, ok := it.logicalKey(it.raw.Key()); ok {
+			it.key = key
+			return
+		}
+	}
+}
+
+// logicalKey returns the logical key a raw key belongs to if the raw key is
+// the one representing the value: the manifest, or the first chunk of a
+// value written without a manifest
+func (it *ValueIterator) logicalKey(raw []byte) ([]byte, bool) {
+	key, isManifest, ok := it.k.keys().parse(raw)
+	if !ok {
+		return nil, false
+	}
+	if bytes.Compare(key, it.start) < 0 || (it.end != nil && bytes.Compare(key, it.end) >= 0) {
+		return nil, false
+	}
+	if !isManifest {
+		return key, !it.k.Has(it.k.keys().manifestKey(key))
+	}
+
+	return key, true
+}
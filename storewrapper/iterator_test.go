@@ -0,0 +1,46 @@
+package storewrapper_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStoreIterator(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	values := map[string]string{
+		"a": sampleSmall,
+		"b": sampleLarge,
+		"c": "",
+		"d": sampleSmall,
+	}
+	for k, v := range values {
+		_, err := stw.SetW([]byte(k), []byte(v))
+		require.Nil(t, err)
+	}
+	// value without a manifest
+	stw.Set([]byte("e\x00\x00\x00\x00\x00\x00\x00\x00"), []byte("legacy"))
+	values["e"] = "legacy"
+
+	var keys []string
+	it := stw.IteratorW(nil, nil)
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+		value, err := it.ValueW()
+		require.Nil(t, err)
+		require.Equal(t, values[string(it.Key())], string(value))
+	}
+	it.Close()
+	require.Equal(t, []string{"a", "b", "c", "d", "e"}, keys)
+
+	keys = nil
+	it = stw.ReverseIteratorW([]byte("b"), []byte("d"))
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	it.Close()
+	require.Equal(t, []string{"c", "b"}, keys)
+}
@@ -1,8 +1,9 @@
 package storewrapper
 
 import (
-	"encoding/binary"
+	"crypto/cipher"
 	"fmt"
+
 	"github.com/cosmos/cosmos-sdk/store/types"
 )
 
@@ -10,164 +11,431 @@ var _ types.KVStore = KVStore{}
 
 const (
 	DefaultChunkSize = 1024 * 5
+	MinChunkSize     = 16
+	MaxChunkSize     = DefaultChunkSize
 )
 
 type KVStore struct {
 	types.KVStore
-	ChunkSize int
+	ChunkSize    int
+	ChunkHashes  bool
+	Compressor   Compressor
+	Cipher       cipher.AEAD
+	Dedup        bool
+	MaxValueSize int
+
+	Versioned      bool
+	RetainVersions int
+
+	Listener  Listener
+	Cache     *ValueCache
+	GasMeter  types.GasMeter
+	GasConfig types.GasConfig
+
+	layout  keyLayout
+	lastOps Ops
+	obs     *observation
 }
 
-// Create a wrapper around existing store
+// Create a wrapper around existing store; chunkSize 0 means DefaultChunkSize.
+// Writes of new values fail with a *ChunkSizeError if the chunk size is out
+// of [MinChunkSize, MaxChunkSize]. Every value keeps the chunk size it was
+// written with, so the chunk size can be changed at any time.
 func NewKVStore(store types.KVStore, chunkSize int) *KVStore {
-	if chunkSize < 16 || chunkSize > DefaultChunkSize {
+	if chunkSize == 0 {
 		chunkSize = DefaultChunkSize
 	}
 	return &KVStore{
-		KVStore:   store,
+		KVStore:   &opCounter{KVStore: store},
 		ChunkSize: chunkSize,
 	}
 }
 
-// Get all parts of stored value
-func (k *KVStore) GetW(keySrc []byte) (res []byte, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			nErr, nOk := r.(error)
-			if nOk {
-				err = nErr
-			} else {
-				err = fmt.Errorf("%v", r)
-			}
-		}
-	}()
+// WithChunkHashes returns the wrapper with per-chunk checksums enabled or
+// disabled for subsequent writes. A checksum of the whole value is always stored.
+func (k *KVStore) WithChunkHashes(enabled bool) *KVStore {
+	k.ChunkHashes = enabled
+	return k
+}
 
-	parts := 0
-	res = make([]byte, 0)
-	b := make([]byte, 8)
+// WithNamespace returns the wrapper storing subsequent values under prefix
+// with an unambiguous key encoding, so wrapped values can't overlap with
+// each other or with raw entries outside the prefix. Values written without
+// a namespace are not visible through it; use MigrateLegacyW to move them.
+func (k *KVStore) WithNamespace(prefix []byte) *KVStore {
+	k.layout = namespacedLayout{namespace: append([]byte{}, prefix...)}
+	return k
+}
 
-	binary.LittleEndian.PutUint64(b, uint64(parts))
-	key := append(keySrc, b...)
-	for k.Has(key) {
-		ba := k.Get(key)
-		res = append(res, ba...)
+// Namespace returns the namespace prefix and whether one is set
+func (k *KVStore) Namespace() ([]byte, bool) {
+	l, ok := k.layout.(namespacedLayout)
+	return l.namespace, ok
+}
 
-		parts++
-		binary.LittleEndian.PutUint64(b, uint64(parts))
+// Get all parts of stored value
+func (k *KVStore) GetW(keySrc []byte) (res []byte, err error) {
+	o := k.observe(OpGet, keySrc)
+	defer o.done(&err)
+	defer recoverError(&err)
+	defer k.trackOps()()
 
-		key = append(keySrc, b...)
-	}
+	var parts int
+	res, parts, _, err = k.get(keySrc)
+	o.set(len(res), parts)
 
 	return
 }
 
 // Check if store has value; return number of parts, hasValue, error
 func (k *KVStore) HasW(keySrc []byte) (parts int, ok bool, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			nErr, nOk := r.(error)
-			if nOk {
-				err = nErr
-			} else {
-				err = fmt.Errorf("%v", r)
-			}
-		}
-	}()
-
-	parts = 0
-	b := make([]byte, 8)
-
-	binary.LittleEndian.PutUint64(b, uint64(parts))
-	key := append(keySrc, b...)
-
-	for k.Has(key) {
-		parts++
-		binary.LittleEndian.PutUint64(b, uint64(parts))
-
-		key = append(keySrc, b...)
+	o := k.observe(OpHas, keySrc)
+	defer o.done(&err)
+	defer recoverError(&err)
+	defer k.trackOps()()
+
+	m, ok, err := k.getManifest(keySrc)
+	if err != nil {
+		return 0, false, err
+	}
+	if ok {
+		o.set(int(m.Length), int(m.Chunks))
+		return int(m.Chunks), true, nil
 	}
 
+	parts = k.countLegacy(keySrc)
 	ok = parts > 0
+	o.set(0, parts)
 
 	return
 }
 
 // Add or update value in store; if update - delete old parts; return parts stored, error
+//
+// New chunks are written under a new generation and the manifest is switched
+// to them with a single write, so if SetW fails halfway readers keep seeing
+// the old value. Chunks left behind by a failed write are never read.
+//
+// An empty value is stored as a manifest without chunks, so unlike values
+// written before manifests were introduced it exists: HasW returns (0, true)
+// until it is deleted with DeleteW.
 func (k *KVStore) SetW(keySrc, valueSrc []byte) (parts int, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			nErr, nOk := r.(error)
-			if nOk {
-				err = nErr
-			} else {
-				err = fmt.Errorf("%v", r)
-			}
-		}
-	}()
+	o := k.observe(OpSet, keySrc)
+	defer o.done(&err)
+	defer recoverError(&err)
+	defer k.trackOps()()
 
-	chunkSize := k.ChunkSize
-	lenVal := len(valueSrc)
+	if err := k.ValidateSize(len(valueSrc)); err != nil {
+		return 0, err
+	}
+	if err := k.ValidateChunkSize(); err != nil {
+		return 0, err
+	}
+	if err := k.validateDedup(); err != nil {
+		return 0, err
+	}
 
-	parts = 0
-	b := make([]byte, 8)
+	old, err := k.current(keySrc)
+	if err != nil {
+		return 0, err
+	}
+
+	stored, codecName, err := k.compress(valueSrc)
+	if err != nil {
+		return 0, err
+	}
+	stored, nonce, err := k.encrypt(keySrc, stored)
+	if err != nil {
+		return 0, err
+	}
 
-	binary.LittleEndian.PutUint64(b, uint64(parts))
-	key := append(keySrc, b...)
+	m := newManifest(stored, k.chunkSize(), k.ChunkHashes || k.Dedup)
+	m.Codec = codecName
+	m.Nonce = nonce
+	m.Dedup = k.Dedup
+	m.Generation = old.nextGeneration()
 
 	// add new value
-	for lenVal > 0 {
-		if lenVal > chunkSize {
-			k.Set(key, valueSrc[parts*chunkSize:(parts+1)*chunkSize])
-		} else {
-			k.Set(key, valueSrc[parts*chunkSize:])
+	for i := uint64(0); i < m.Chunks; i++ {
+		k.writeChunk(keySrc, m, i, m.chunk(stored, i))
+	}
+
+	// switch to it and delete old values
+	k.commit(keySrc, old, m)
+	o.set(len(valueSrc), int(m.Chunks))
+
+	return int(m.Chunks), nil
+}
+
+// Delete all parts of stored value, including older versions
+//
+// The manifest is deleted first, so if DeleteW fails halfway the value is
+// either intact or gone.
+func (k *KVStore) DeleteW(keySrc []byte) (parts int, err error) {
+	o := k.observe(OpDelete, keySrc)
+	defer o.done(&err)
+	defer recoverError(&err)
+	defer k.trackOps()()
+
+	old, err := k.current(keySrc)
+	if err != nil {
+		return 0, err
+	}
+
+	k.Cache.invalidate(keySrc)
+	k.Delete(k.keys().manifestKey(keySrc))
+	k.deleteChunks(keySrc, old)
+	if err := k.deleteVersions(keySrc, old); err != nil {
+		return 0, err
+	}
+	o.set(0, int(old.Chunks))
+
+	return int(old.Chunks), nil
+}
+
+// get reads a value and its number of parts and reports whether it exists;
+// a missing value is empty
+func (k *KVStore) get(keySrc []byte) ([]byte, int, bool, error) {
+	if res, parts, reads, ok := k.Cache.get(keySrc); ok {
+		k.replayReads(reads)
+		return res, parts, true, nil
+	}
+
+	trace := k.traceReads()
+	res, parts, ok, err := k.load(keySrc)
+	if reads := trace(); ok && err == nil {
+		k.Cache.put(keySrc, res, parts, reads)
+	}
+
+	return res, parts, ok, err
+}
+
+// load reads a value from the store like get
+func (k *KVStore) load(keySrc []byte) ([]byte, int, bool, error) {
+	m, ok, err := k.getManifest(keySrc)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if !ok {
+		res, parts := k.getLegacy(keySrc)
+		return res, parts, parts > 0, nil
+	}
+
+	res, err := k.read(keySrc, m)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	return res, int(m.Chunks), true, nil
+}
+
+// read reassembles, verifies, decrypts and decompresses the value described
+// by m
+func (k *KVStore) read(keySrc []byte, m manifest) ([]byte, error) {
+	res := make([]byte, 0, m.Length)
+	for i := uint64(0); i < m.Chunks; i++ {
+		ba := k.Get(k.chunkKey(keySrc, m, i))
+		if err := m.verifyChunk(keySrc, i, ba); err != nil {
+			return nil, err
 		}
+		res = append(res, ba...)
+	}
 
-		parts++
-		binary.LittleEndian.PutUint64(b, uint64(parts))
-		key = append(keySrc, b...)
+	if err := m.verify(keySrc, res); err != nil {
+		return nil, err
+	}
 
-		lenVal -= chunkSize
+	res, err := k.decrypt(keySrc, m, res)
+	if err != nil {
+		return nil, err
 	}
 
-	i := parts
+	return k.decompress(m, res)
+}
+
+// commit switches the value to m, whose chunks are already written, with a
+// single write of the manifest. Chunks of the old value m doesn't share are
+// deleted or kept as an older version.
+func (k *KVStore) commit(keySrc []byte, old, m manifest) {
+	k.Cache.invalidate(keySrc)
 
-	// delete old values
-	for k.Has(key) {
-		k.Delete(key)
-		i++
-		binary.LittleEndian.PutUint64(b, uint64(i))
-		key = append(keySrc, b...)
+	if !k.keepsVersions(old) {
+		k.Set(k.keys().manifestKey(keySrc), m.bytes())
+		k.deleteReplaced(keySrc, old, m)
+		return
 	}
-	return
+
+	if exists := old.Version > 0 || old.Chunks > 0; !exists {
+		m.ValueVersion, m.OldestVersion = 1, 1
+	} else {
+		if old.Version == 0 {
+			old, _ = k.legacyManifest(keySrc)
+		}
+		if old.ValueVersion == 0 {
+			old.ValueVersion, old.OldestVersion = 1, 1
+		}
+
+		m.ValueVersion, m.OldestVersion = old.ValueVersion+1, old.OldestVersion
+		k.Set(k.keys().versionKey(keySrc, old.ValueVersion), old.bytes())
+	}
+
+	k.Set(k.keys().manifestKey(keySrc), m.bytes())
 }
 
-// Delete all parts of stored value
-func (k *KVStore) DeleteW(keySrc []byte) (parts int, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			nErr, nOk := r.(error)
-			if nOk {
-				err = nErr
-			} else {
-				err = fmt.Errorf("%v", r)
-			}
+// keys returns the key layout of the wrapper
+func (k *KVStore) keys() keyLayout {
+	if k.layout == nil {
+		return legacyLayout{}
+	}
+	return k.layout
+}
+
+// ValidateChunkSize checks the chunk size of new values
+func (k *KVStore) ValidateChunkSize() error {
+	if size := k.chunkSize(); size < MinChunkSize || size > MaxChunkSize {
+		return &ChunkSizeError{Size: size, Min: MinChunkSize, Max: MaxChunkSize}
+	}
+	return nil
+}
+
+// chunkSize returns the chunk size for new values
+func (k *KVStore) chunkSize() int {
+	if k.ChunkSize == 0 {
+		return DefaultChunkSize
+	}
+	return k.ChunkSize
+}
+
+// getManifest returns the manifest of a value and whether it exists
+func (k *KVStore) getManifest(keySrc []byte) (m manifest, ok bool, err error) {
+	bz := k.Get(k.keys().manifestKey(keySrc))
+	if bz == nil {
+		return m, false, nil
+	}
+
+	m, err = decodeManifest(bz)
+	if err != nil {
+		return m, false, errCorrupted(keySrc, -1, "bad manifest: %v", err)
+	}
+	if m.Version > ManifestVersion {
+		return m, false, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+
+	return m, true, nil
+}
+
+// current returns the manifest of the stored value in either layout; only
+// Chunks and Generation are set for values without a manifest
+func (k *KVStore) current(keySrc []byte) (manifest, error) {
+	m, ok, err := k.getManifest(keySrc)
+	if err != nil || ok {
+		return m, err
+	}
+
+	return manifest{Chunks: uint64(k.countLegacy(keySrc))}, nil
+}
+
+// chunkKey returns the raw key of chunk i of the value described by m
+func (k *KVStore) chunkKey(keySrc []byte, m manifest, i uint64) []byte {
+	if m.Dedup {
+		return k.keys().blobKey(m.ChunkHashes[i])
+	}
+	return k.keys().chunkKey(keySrc, m.index(i))
+}
+
+// deleteChunks deletes the chunks of the value described by m
+func (k *KVStore) deleteChunks(keySrc []byte, m manifest) {
+	for i := uint64(0); i < m.Chunks; i++ {
+		k.deleteChunk(keySrc, m, i)
+	}
+}
+
+// deleteReplaced deletes the chunks of old that m doesn't share, i.e. all of
+// them unless m is an update in place
+func (k *KVStore) deleteReplaced(keySrc []byte, old, m manifest) {
+	for i := uint64(0); i < old.Chunks; i++ {
+		if i < m.Chunks && old.Dedup == m.Dedup && old.generation(i) == m.generation(i) {
+			continue
 		}
-	}()
+		k.deleteChunk(keySrc, old, i)
+	}
+}
 
-	parts = 0
-	b := make([]byte, 8)
+// deleteChunk deletes chunk i of the value described by m
+func (k *KVStore) deleteChunk(keySrc []byte, m manifest, i uint64) {
+	if m.Dedup {
+		k.releaseChunk(m.ChunkHashes[i], m.chunkLen(i))
+	} else {
+		k.Delete(k.chunkKey(keySrc, m, i))
+		k.obs.deleted(m.chunkLen(i))
+	}
+}
 
-	binary.LittleEndian.PutUint64(b, uint64(parts))
-	key := append(keySrc, b...)
+// validateDedup checks that deduplicated chunks have a namespace to go to
+func (k *KVStore) validateDedup() error {
+	if _, ok := k.Namespace(); k.Dedup && !ok {
+		return ErrNoNamespace
+	}
+	return nil
+}
 
-	// delete old values
-	for k.Has(key) {
-		k.Delete(key)
+// getLegacy reads a value written without a manifest by probing chunk keys;
+// return value, number of parts
+func (k *KVStore) getLegacy(keySrc []byte) ([]byte, int) {
+	res := make([]byte, 0)
+	if !k.keys().hasLegacy() {
+		return res, 0
+	}
 
-		parts++
-		binary.LittleEndian.PutUint64(b, uint64(parts))
+	for i := 0; ; i++ {
+		ba := k.Get(k.keys().chunkKey(keySrc, uint64(i)))
+		if ba == nil {
+			return res, i
+		}
+		res = append(res, ba...)
+	}
+}
 
-		key = append(keySrc, b...)
+// legacyManifest describes a value written without a manifest. All chunks
+// but the last one have the size of the first chunk.
+func (k *KVStore) legacyManifest(keySrc []byte) (m manifest, ok bool) {
+	parts := k.countLegacy(keySrc)
+	if parts == 0 {
+		return m, false
 	}
 
-	return
+	first := len(k.Get(k.keys().chunkKey(keySrc, 0)))
+	last := len(k.Get(k.keys().chunkKey(keySrc, uint64(parts-1))))
+
+	return manifest{
+		Length:    uint64((parts-1)*first + last),
+		Chunks:    uint64(parts),
+		ChunkSize: uint64(first),
+	}, true
+}
+
+// countLegacy counts chunks of a value written without a manifest
+func (k *KVStore) countLegacy(keySrc []byte) int {
+	if !k.keys().hasLegacy() {
+		return 0
+	}
+
+	parts := 0
+	for k.Has(k.keys().chunkKey(keySrc, uint64(parts))) {
+		parts++
+	}
+
+	return parts
+}
+
+// recoverError turns a panic of the underlying store into an error
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		nErr, nOk := r.(error)
+		if nOk {
+			*err = nErr
+		} else {
+			*err = fmt.Errorf("%v", r)
+		}
+	}
 }
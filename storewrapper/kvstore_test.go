@@ -4,9 +4,9 @@ import (
 	"io/ioutil"
 	"testing"
 
+	"github.com/corestario/cosmos-utils/storewrapper"
 	"github.com/cosmos/cosmos-sdk/store"
 	sdk "github.com/cosmos/cosmos-sdk/types"
-	"github.com/corestario/cosmos_utils/storewrapper"
 	"github.com/stretchr/testify/require"
 	abci "github.com/tendermint/tendermint/abci/types"
 	"github.com/tendermint/tendermint/libs/log"
@@ -36,6 +36,12 @@ func makeTestStore() (*storewrapper.KVStore, error) {
 
 }
 
+// rawChunkKey returns the legacy layout key of chunk i written by the given
+// generation of a value
+func rawChunkKey(key []byte, generation, i byte) []byte {
+	return append(append([]byte{}, key...), i, 0, 0, 0, generation, 0, 0, 0)
+}
+
 func TestKVStore(t *testing.T) {
 	stw, err := makeTestStore()
 	require.Nil(t, err)
@@ -64,6 +70,178 @@ func TestKVStore(t *testing.T) {
 	require.Equal(t, n2, parts)
 }
 
+func TestKVStoreManifest(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	key := []byte("Ezekiel")
+	parts, err := stw.SetW(key, []byte(sampleLarge))
+	require.Nil(t, err)
+	require.Equal(t, (len(sampleLarge)+99)/100, parts)
+
+	// chunks lost in the middle are detected instead of truncating the value
+	stw.Delete(rawChunkKey(key, 1, 3))
+	_, err = stw.GetW(key)
+	require.True(t, storewrapper.IsCorruption(err))
+	require.Equal(t, 3, err.(*storewrapper.CorruptionError).Chunk)
+
+	n, err := stw.DeleteW(key)
+	require.Nil(t, err)
+	require.Equal(t, parts, n)
+
+	_, ok, err := stw.HasW(key)
+	require.Nil(t, err)
+	require.False(t, ok)
+	require.False(t, stw.Has(rawChunkKey(key, 1, 4)))
+}
+
+func TestKVStoreEmptyValue(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+
+	key := []byte("Marsellus")
+	parts, err := stw.SetW(key, []byte{})
+	require.Nil(t, err)
+	require.Equal(t, 0, parts)
+
+	// the empty value exists without chunks
+	n, ok, err := stw.HasW(key)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, 0, n)
+
+	res, err := stw.GetW(key)
+	require.Nil(t, err)
+	require.Empty(t, res)
+
+	_, err = stw.DeleteW(key)
+	require.Nil(t, err)
+	_, ok, err = stw.HasW(key)
+	require.Nil(t, err)
+	require.False(t, ok)
+}
+
+func TestKVStoreChecksums(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	key := []byte("Ezekiel")
+	// without chunk hashes the whole value checksum catches a swapped chunk
+	_, err = stw.SetW(key, []byte(sampleLarge))
+	require.Nil(t, err)
+	first, second := stw.Get(rawChunkKey(key, 1, 1)), stw.Get(rawChunkKey(key, 1, 2))
+	stw.Set(rawChunkKey(key, 1, 1), second)
+	stw.Set(rawChunkKey(key, 1, 2), first)
+
+	_, err = stw.GetW(key)
+	require.True(t, storewrapper.IsCorruption(err))
+	require.Equal(t, -1, err.(*storewrapper.CorruptionError).Chunk)
+
+	// with chunk hashes the bad chunk is reported
+	_, err = stw.WithChunkHashes(true).SetW(key, []byte(sampleLarge))
+	require.Nil(t, err)
+	bad := stw.Get(rawChunkKey(key, 2, 5))
+	bad[0] ^= 0xFF
+	stw.Set(rawChunkKey(key, 2, 5), bad)
+
+	_, err = stw.GetW(key)
+	require.True(t, storewrapper.IsCorruption(err))
+	require.Equal(t, 5, err.(*storewrapper.CorruptionError).Chunk)
+
+	_, err = stw.SetW(key, []byte(sampleLarge))
+	require.Nil(t, err)
+	res, err := stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+}
+
+func TestKVStoreChunkSize(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	require.Equal(t, storewrapper.DefaultChunkSize, stw.ChunkSize)
+	stw.ChunkSize = 100
+
+	key := []byte("Ezekiel")
+	_, err = stw.SetW(key, []byte(sampleLarge[:450]))
+	require.Nil(t, err)
+
+	// values keep the chunk size they were written with
+	stw.ChunkSize = 200
+	res, err := stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge[:450], string(res))
+	parts, err := stw.AppendW(key, []byte(sampleLarge[450:]))
+	require.Nil(t, err)
+	require.Equal(t, (len(sampleLarge)+99)/100-4, parts)
+	res, err = stw.GetRangeW(key, 420, 100)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge[420:520], string(res))
+
+	parts, err = stw.SetW(key, []byte(sampleLarge))
+	require.Nil(t, err)
+	require.Equal(t, (len(sampleLarge)+199)/200, parts)
+
+	for _, size := range []int{-1, storewrapper.MinChunkSize - 1, storewrapper.MaxChunkSize + 1} {
+		stw.ChunkSize = size
+		expected := &storewrapper.ChunkSizeError{
+			Size: size,
+			Min:  storewrapper.MinChunkSize,
+			Max:  storewrapper.MaxChunkSize,
+		}
+
+		_, err = stw.SetW(key, []byte(sampleSmall))
+		require.Equal(t, expected, err)
+		_, err = stw.AppendW([]byte("missing"), []byte(sampleSmall))
+		require.Equal(t, expected, err)
+		_, err = stw.NewValueWriter(key).Write([]byte(sampleSmall))
+		require.Equal(t, expected, err)
+	}
+
+	res, err = stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+}
+
+func TestKVStoreLegacyLayout(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	// value written by probing-era SetW: key||uint64LE(i) without a manifest
+	key := []byte("Ezekiel")
+	value := []byte(sampleLarge)
+	legacyParts := 0
+	for i := 0; i*100 < len(value); i++ {
+		end := (i + 1) * 100
+		if end > len(value) {
+			end = len(value)
+		}
+		stw.Set(rawChunkKey(key, 0, byte(i)), value[i*100:end])
+		legacyParts++
+	}
+
+	res, err := stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, value, res)
+
+	n, ok, err := stw.HasW(key)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, legacyParts, n)
+
+	// overwriting a legacy value removes its stale chunks
+	parts, err := stw.SetW(key, []byte(sampleSmall))
+	require.Nil(t, err)
+	require.Equal(t, 2, parts)
+	require.False(t, stw.Has(rawChunkKey(key, 0, 2)))
+
+	res, err = stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, sampleSmall, string(res))
+}
+
 var sampleSmall = `17 And I will execute great vengeance upon them with furious rebukes; and they shall know that I am the Lord, when I shall lay my vengeance upon them.`
 
 var sampleLarge = `Ezekiel 25 King James Version (KJV)
@@ -0,0 +1,297 @@
+package storewrapper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/cosmos/cosmos-sdk/store/types"
+)
+
+// keyLayout maps logical keys to the raw keys of their records
+type keyLayout interface {
+	chunkKey(key []byte, i uint64) []byte
+	manifestKey(key []byte) []byte
+	versionKey(key []byte, version uint64) []byte
+
+	// blobKey and refKey return the keys of a deduplicated chunk and of its
+	// reference count
+	blobKey(hash []byte) []byte
+	refKey(hash []byte) []byte
+
+	// rawRange returns a raw key range covering all records of logical
+	// keys in [start, end); it may include records of other keys
+	rawRange(start, end []byte) ([]byte, []byte)
+
+	// parse returns the logical key of a raw key if the raw key is the
+	// record a value is found by: its manifest or, for values without a
+	// manifest, its first chunk
+	parse(raw []byte) (key []byte, isManifest bool, ok bool)
+
+	// record returns what a raw key stores if it looks like a record of the
+	// layout
+	record(raw []byte) (rec record, ok bool)
+
+	// hasLegacy reports whether values without a manifest can exist
+	hasLegacy() bool
+}
+
+// recordKind is the kind of a raw record
+type recordKind byte
+
+const (
+	recordManifest recordKind = iota
+	recordChunk
+	recordVersion
+	recordBlob
+	recordRef
+)
+
+// record describes a raw record: the logical key and the raw chunk index or
+// version, or the hash of a deduplicated chunk
+type record struct {
+	kind recordKind
+	key  []byte
+	n    uint64
+}
+
+// legacyLayout stores chunk i of key under key||uint64LE(i) and the manifest
+// under the reserved chunk index. Version records use indices of the unused
+// chunk generation 0xFFFFFFFF. A key that is a prefix of another key can
+// collide with it, so it is only kept for existing data.
+type legacyLayout struct{}
+
+var legacyManifestSuffix = legacyChunkSuffix(math.MaxUint64)
+
+func legacyChunkSuffix(i uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, i)
+	return b
+}
+
+func (legacyLayout) chunkKey(key []byte, i uint64) []byte {
+	return concat(key, legacyChunkSuffix(i))
+}
+
+func (legacyLayout) manifestKey(key []byte) []byte {
+	return concat(key, legacyManifestSuffix)
+}
+
+func (legacyLayout) versionKey(key []byte, version uint64) []byte {
+	return concat(key, legacyChunkSuffix(math.MaxUint32<<32|version))
+}
+
+func (legacyLayout) blobKey(hash []byte) []byte {
+	panic(ErrNoNamespace)
+}
+
+func (legacyLayout) refKey(hash []byte) []byte {
+	panic(ErrNoNamespace)
+}
+
+func (legacyLayout) rawRange(start, end []byte) ([]byte, []byte) {
+	// key||suffix may sort after end if key is a prefix of end, so the raw
+	// range covers everything sharing the first byte of end
+	if len(end) == 0 {
+		return start, nil
+	}
+	return start, types.PrefixEndBytes(end[:1])
+}
+
+func (legacyLayout) parse(raw []byte) ([]byte, bool, bool) {
+	if len(raw) < len(legacyManifestSuffix) {
+		return nil, false, false
+	}
+
+	key := concat(raw[:len(raw)-len(legacyManifestSuffix)])
+	switch suffix := raw[len(key):]; {
+	case bytes.Equal(suffix, legacyManifestSuffix):
+		return key, true, true
+	case bytes.Equal(suffix, legacyChunkSuffix(0)):
+		return key, false, true
+	}
+
+	return nil, false, false
+}
+
+func (legacyLayout) record(raw []byte) (record, bool) {
+	if len(raw) < len(legacyManifestSuffix) {
+		return record{}, false
+	}
+
+	key := concat(raw[:len(raw)-len(legacyManifestSuffix)])
+	switch suffix := binary.LittleEndian.Uint64(raw[len(key):]); {
+	case suffix == math.MaxUint64:
+		return record{kind: recordManifest, key: key}, true
+	case suffix>>32 == math.MaxUint32:
+		return record{kind: recordVersion, key: key, n: suffix & math.MaxUint32}, true
+	default:
+		return record{kind: recordChunk, key: key, n: suffix}, true
+	}
+}
+
+func (legacyLayout) hasLegacy() bool { return true }
+
+// namespacedLayout stores records under
+//
+//	namespace || escape(key) || 0x00 0x01 || tag [|| uint64BE(i or version)]
+//
+// where escape replaces every 0x00 of the key with 0x00 0xFF. The encoding is
+// prefix-free and keeps the order of logical keys, so records of different
+// keys never overlap and logical ranges map to raw ranges. Raw keys starting
+// with namespace || 0x00 0x02..0xFE are reserved: deduplicated chunks and
+// their reference counts are stored under namespace || 0x00 0x02 || hash and
+// namespace || 0x00 0x03 || hash.
+type namespacedLayout struct {
+	namespace []byte
+}
+
+const (
+	tagManifest byte = 0x00
+	tagChunk    byte = 0x01
+	tagVersion  byte = 0x02
+)
+
+var (
+	keyTerminator = []byte{0x00, 0x01}
+	blobPrefix    = []byte{0x00, 0x02}
+	refPrefix     = []byte{0x00, 0x03}
+)
+
+func (l namespacedLayout) base(key []byte) []byte {
+	res := make([]byte, 0, len(l.namespace)+len(key)+len(keyTerminator)+9)
+	res = append(res, l.namespace...)
+	return escapeKey(res, key)
+}
+
+func (l namespacedLayout) chunkKey(key []byte, i uint64) []byte {
+	res := append(l.base(key), keyTerminator...)
+	res = append(res, tagChunk, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(res[len(res)-8:], i)
+	return res
+}
+
+func (l namespacedLayout) manifestKey(key []byte) []byte {
+	return append(append(l.base(key), keyTerminator...), tagManifest)
+}
+
+func (l namespacedLayout) versionKey(key []byte, version uint64) []byte {
+	res := append(l.base(key), keyTerminator...)
+	res = append(res, tagVersion, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(res[len(res)-8:], version)
+	return res
+}
+
+func (l namespacedLayout) blobKey(hash []byte) []byte {
+	return concat(l.namespace, blobPrefix, hash)
+}
+
+func (l namespacedLayout) refKey(hash []byte) []byte {
+	return concat(l.namespace, refPrefix, hash)
+}
+
+func (l namespacedLayout) rawRange(start, end []byte) ([]byte, []byte) {
+	var rawStart []byte
+	if len(start) > 0 || len(l.namespace) > 0 {
+		rawStart = l.base(start)
+	}
+	if end == nil {
+		return rawStart, types.PrefixEndBytes(l.namespace)
+	}
+	return rawStart, l.base(end)
+}
+
+func (l namespacedLayout) parse(raw []byte) ([]byte, bool, bool) {
+	if !bytes.HasPrefix(raw, l.namespace) {
+		return nil, false, false
+	}
+
+	key, rest, ok := unescapeKey(raw[len(l.namespace):])
+	if !ok || !bytes.Equal(rest, []byte{tagManifest}) {
+		return nil, false, false
+	}
+
+	return key, true, true
+}
+
+func (l namespacedLayout) record(raw []byte) (record, bool) {
+	if !bytes.HasPrefix(raw, l.namespace) {
+		return record{}, false
+	}
+
+	rest := raw[len(l.namespace):]
+	switch {
+	case bytes.HasPrefix(rest, blobPrefix) && len(rest) == len(blobPrefix)+hashSize:
+		return record{kind: recordBlob, key: concat(rest[len(blobPrefix):])}, true
+	case bytes.HasPrefix(rest, refPrefix) && len(rest) == len(refPrefix)+hashSize:
+		return record{kind: recordRef, key: concat(rest[len(refPrefix):])}, true
+	}
+
+	key, rest, ok := unescapeKey(rest)
+	switch {
+	case !ok:
+		return record{}, false
+	case bytes.Equal(rest, []byte{tagManifest}):
+		return record{kind: recordManifest, key: key}, true
+	case len(rest) == 9 && rest[0] == tagChunk:
+		return record{kind: recordChunk, key: key, n: binary.BigEndian.Uint64(rest[1:])}, true
+	case len(rest) == 9 && rest[0] == tagVersion:
+		return record{kind: recordVersion, key: key, n: binary.BigEndian.Uint64(rest[1:])}, true
+	}
+
+	return record{}, false
+}
+
+func (namespacedLayout) hasLegacy() bool { return false }
+
+// escapeKey appends key to dst replacing 0x00 with 0x00 0xFF
+func escapeKey(dst, key []byte) []byte {
+	for _, b := range key {
+		if b == 0x00 {
+			dst = append(dst, 0x00, 0xFF)
+		} else {
+			dst = append(dst, b)
+		}
+	}
+	return dst
+}
+
+// unescapeKey decodes an escaped key up to its terminator and returns the
+// rest of the raw key
+func unescapeKey(raw []byte) (key, rest []byte, ok bool) {
+	key = make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != 0x00 {
+			key = append(key, raw[i])
+			continue
+		}
+		if i+1 == len(raw) {
+			return nil, nil, false
+		}
+
+		switch raw[i+1] {
+		case 0xFF:
+			key = append(key, 0x00)
+			i++
+		case keyTerminator[1]:
+			return key, raw[i+2:], true
+		default:
+			return nil, nil, false
+		}
+	}
+
+	return nil, nil, false
+}
+
+func concat(parts ...[]byte) []byte {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+
+	res := make([]byte, 0, n)
+	for _, p := range parts {
+		res = append(res, p...)
+	}
+	return res
+}
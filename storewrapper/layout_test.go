@@ -0,0 +1,89 @@
+package storewrapper_test
+
+import (
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStoreNamespace(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.WithNamespace([]byte("ns/")).ChunkSize = 100
+
+	// keys that collide in the legacy layout
+	keys := []string{"a", "a\x00\x00\x00\x00\x00\x00\x00\x00", "a\x00", "ab", "b"}
+	for i, key := range keys {
+		_, err := stw.SetW([]byte(key), []byte(sampleLarge[:(i+1)*150]))
+		require.Nil(t, err)
+	}
+	raw := []byte("a\x00\x00\x00\x00\x00\x00\x00\x00")
+	stw.Set(raw, []byte("raw"))
+
+	for i, key := range keys {
+		res, err := stw.GetW([]byte(key))
+		require.Nil(t, err)
+		require.Equal(t, sampleLarge[:(i+1)*150], string(res))
+	}
+	require.Equal(t, []byte("raw"), stw.Get(raw))
+
+	var got []string
+	it := stw.IteratorW(nil, nil)
+	for ; it.Valid(); it.Next() {
+		got = append(got, string(it.Key()))
+	}
+	it.Close()
+	require.Equal(t, []string{"a", "a\x00", "a\x00\x00\x00\x00\x00\x00\x00\x00", "ab", "b"}, got)
+
+	got = nil
+	it = stw.ReverseIteratorW([]byte("a\x00"), []byte("ab"))
+	for ; it.Valid(); it.Next() {
+		got = append(got, string(it.Key()))
+	}
+	it.Close()
+	require.Equal(t, []string{"a\x00\x00\x00\x00\x00\x00\x00\x00", "a\x00"}, got)
+
+	_, err = stw.DeleteW([]byte("a"))
+	require.Nil(t, err)
+	_, ok, err := stw.HasW([]byte("a"))
+	require.Nil(t, err)
+	require.False(t, ok)
+	_, ok, err = stw.HasW([]byte("a\x00"))
+	require.Nil(t, err)
+	require.True(t, ok)
+}
+
+func TestKVStoreMigrateLegacy(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	_, err = stw.SetW([]byte("Ezekiel"), []byte(sampleLarge))
+	require.Nil(t, err)
+	_, err = stw.SetW([]byte("Ezekiel 25:17"), []byte(sampleSmall))
+	require.Nil(t, err)
+
+	_, err = stw.MigrateLegacyW([]byte("Ezekiel"))
+	require.Equal(t, storewrapper.ErrNoNamespace, err)
+
+	stw.WithNamespace([]byte("ns/"))
+	_, ok, err := stw.HasW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	moved, err := stw.MigrateLegacyW([]byte("Ezekiel"), []byte("Ezekiel 25:17"), []byte("missing"))
+	require.Nil(t, err)
+	require.Equal(t, 2, moved)
+
+	res, err := stw.GetW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+	res, err = stw.GetW([]byte("Ezekiel 25:17"))
+	require.Nil(t, err)
+	require.Equal(t, sampleSmall, string(res))
+
+	_, ok, err = stw.Legacy().HasW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	require.False(t, ok)
+}
@@ -0,0 +1,169 @@
+package storewrapper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+)
+
+const (
+	// ManifestVersion is the format version written by SetW
+	ManifestVersion = 8
+)
+
+var cdc = codec.New()
+
+// manifest describes how a value was split into chunks. Values written
+// before manifests were introduced have none and are read by probing
+// sequential chunk keys. Length and hashes describe the stored bytes, which
+// are compressed with Codec if it is set and then encrypted if Nonce is set.
+// Hash is the SHA-256 of the whole stored value, empty for version 1
+// manifests; ChunkHashes is only filled if the store was asked to or Dedup is
+// set, in which case chunks are stored under their hashes. Otherwise chunks
+// of every write get a new Generation, so the chunks of the previous value
+// stay intact until the manifest is replaced. A value updated in place keeps
+// the chunks it didn't change, so ChunkGenerations lists the generation of
+// every chunk unless they are all the same.
+type manifest struct {
+	Version     uint32   `json:"version"`
+	Length      uint64   `json:"length"`
+	Chunks      uint64   `json:"chunks"`
+	ChunkSize   uint64   `json:"chunk_size"`
+	Hash        []byte   `json:"hash"`
+	ChunkHashes [][]byte `json:"chunk_hashes"`
+	Codec       string   `json:"codec"`
+	Nonce       []byte   `json:"nonce"`
+	Dedup       bool     `json:"dedup"`
+	Generation  uint32   `json:"generation"`
+
+	ChunkGenerations []uint32 `json:"chunk_generations"`
+
+	// versions in [OldestVersion, ValueVersion) are kept as version records
+	ValueVersion  uint64 `json:"value_version"`
+	OldestVersion uint64 `json:"oldest_version"`
+}
+
+func newManifest(value []byte, chunkSize int, withChunkHashes bool) manifest {
+	length := len(value)
+	chunks := 0
+	if length > 0 {
+		chunks = (length + chunkSize - 1) / chunkSize
+	}
+
+	m := manifest{
+		Version:   ManifestVersion,
+		Length:    uint64(length),
+		Chunks:    uint64(chunks),
+		ChunkSize: uint64(chunkSize),
+		Hash:      hashOf(value),
+	}
+
+	if withChunkHashes {
+		m.ChunkHashes = make([][]byte, chunks)
+		for i := range m.ChunkHashes {
+			m.ChunkHashes[i] = hashOf(m.chunk(value, uint64(i)))
+		}
+	}
+
+	return m
+}
+
+// index returns the chunk index i is stored under: the generation in the
+// high 32 bits, so values without a manifest have generation 0
+func (m manifest) index(i uint64) uint64 {
+	return uint64(m.generation(i))<<32 | i
+}
+
+// generation returns the generation chunk i was written under
+func (m manifest) generation(i uint64) uint32 {
+	if len(m.ChunkGenerations) > 0 {
+		return m.ChunkGenerations[i]
+	}
+	return m.Generation
+}
+
+// compactGenerations drops ChunkGenerations if all chunks have Generation
+func (m *manifest) compactGenerations() {
+	for _, gen := range m.ChunkGenerations {
+		if gen != m.Generation {
+			return
+		}
+	}
+	m.ChunkGenerations = nil
+}
+
+// nextGeneration returns the generation for the value replacing this one.
+// Generation 0xFFFFFFFF is skipped as its last index is the legacy manifest.
+func (m manifest) nextGeneration() uint32 {
+	return (m.Generation + 1) % math.MaxUint32
+}
+
+// chunk returns the part of value stored under chunk index i
+func (m manifest) chunk(value []byte, i uint64) []byte {
+	end := (i + 1) * m.ChunkSize
+	if end > m.Length {
+		end = m.Length
+	}
+	return value[i*m.ChunkSize : end]
+}
+
+// chunkLen returns the length of chunk i
+func (m manifest) chunkLen(i uint64) uint64 {
+	if rest := m.Length - i*m.ChunkSize; rest < m.ChunkSize {
+		return rest
+	}
+	return m.ChunkSize
+}
+
+// verifyChunk checks a chunk read from the store against the manifest
+func (m manifest) verifyChunk(key []byte, i uint64, ba []byte) error {
+	if ba == nil {
+		return errCorrupted(key, int(i), "missing (%d chunks expected)", m.Chunks)
+	}
+	// chunks kept by an update in place may have no checksum
+	if len(m.ChunkHashes) > 0 && len(m.ChunkHashes[i]) > 0 && !bytes.Equal(hashOf(ba), m.ChunkHashes[i]) {
+		return errCorrupted(key, int(i), "checksum mismatch")
+	}
+
+	return nil
+}
+
+// verify checks a reassembled value against the manifest
+func (m manifest) verify(key, value []byte) error {
+	if uint64(len(value)) != m.Length {
+		return errCorrupted(key, -1, "length %d doesn't match manifest length %d", len(value), m.Length)
+	}
+	if len(m.Hash) > 0 && !bytes.Equal(hashOf(value), m.Hash) {
+		return errCorrupted(key, -1, "checksum mismatch")
+	}
+
+	return nil
+}
+
+func (m manifest) bytes() []byte {
+	return cdc.MustMarshalBinaryBare(m)
+}
+
+func decodeManifest(bz []byte) (m manifest, err error) {
+	err = cdc.UnmarshalBinaryBare(bz, &m)
+	if err == nil && len(m.ChunkHashes) > 0 && uint64(len(m.ChunkHashes)) != m.Chunks {
+		err = fmt.Errorf("manifest has %d chunk hashes for %d chunks", len(m.ChunkHashes), m.Chunks)
+	}
+	if err == nil && m.Dedup && uint64(len(m.ChunkHashes)) != m.Chunks {
+		err = fmt.Errorf("deduplicated manifest has no chunk hashes")
+	}
+	if err == nil && len(m.ChunkGenerations) > 0 && uint64(len(m.ChunkGenerations)) != m.Chunks {
+		err = fmt.Errorf("manifest has %d chunk generations for %d chunks", len(m.ChunkGenerations), m.Chunks)
+	}
+	return
+}
+
+const hashSize = sha256.Size
+
+func hashOf(bz []byte) []byte {
+	h := sha256.Sum256(bz)
+	return h[:]
+}
@@ -0,0 +1,47 @@
+package storewrapper
+
+// Legacy returns a copy of the wrapper without a namespace and cache, e.g. to
+// read or iterate values written before WithNamespace was used
+func (k *KVStore) Legacy() *KVStore {
+	res := *k
+	res.layout = nil
+	res.Cache = nil
+	return &res
+}
+
+// MigrateLegacyW moves the values of the given keys from the layout without
+// namespace into the wrapper's namespace. Keys without a value are skipped.
+// Return number of values moved, error.
+//
+// Raw keys of legacy values must not start with the namespace prefix. Keys
+// can be discovered with k.Legacy().IteratorW if the range holds nothing but
+// legacy values.
+func (k *KVStore) MigrateLegacyW(keys ...[]byte) (moved int, err error) {
+	defer recoverError(&err)
+	defer k.trackOps()()
+
+	if _, ok := k.Namespace(); !ok {
+		return 0, ErrNoNamespace
+	}
+
+	legacy := k.Legacy()
+	for _, key := range keys {
+		value, _, ok, err := legacy.get(key)
+		if err != nil {
+			return moved, err
+		}
+		if !ok {
+			continue
+		}
+
+		if _, err := k.SetW(key, value); err != nil {
+			return moved, err
+		}
+		if _, err := legacy.DeleteW(key); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,109 @@
+package storewrapper
+
+import (
+	"time"
+)
+
+// Operation names an operation reported to a Listener
+type Operation string
+
+const (
+	OpGet           Operation = "get"
+	OpHas           Operation = "has"
+	OpSet           Operation = "set"
+	OpDelete        Operation = "delete"
+	OpAppend        Operation = "append"
+	OpWriteAt       Operation = "write_at"
+	OpStream        Operation = "stream"
+	OpGetMany       Operation = "get_many"
+	OpBatch         Operation = "batch"
+	OpGetVersion    Operation = "get_version"
+	OpPruneVersions Operation = "prune_versions"
+)
+
+// Event describes a finished operation of the wrapper
+type Event struct {
+	Op  Operation
+	Key []byte // nil for operations on several keys
+
+	// Size is the logical size of the values read or written and Chunks the
+	// number of parts read, written or deleted
+	Size   int
+	Chunks int
+
+	// Ops are the operations performed on the underlying store; BytesDeleted
+	// is the size of the chunks deleted from it
+	Ops          Ops
+	BytesDeleted int
+
+	Duration time.Duration
+	Err      error
+}
+
+// Listener is notified about every operation of the wrapper, e.g. to collect
+// metrics. It is called synchronously and must not use the wrapper.
+type Listener interface {
+	OnOperation(e Event)
+}
+
+// WithListener returns the wrapper reporting its operations to l; nil
+// disables reporting
+func (k *KVStore) WithListener(l Listener) *KVStore {
+	k.Listener = l
+	return k
+}
+
+// observation collects an Event while an operation is in progress
+type observation struct {
+	k     *KVStore
+	event Event
+	start time.Time
+}
+
+// observe starts an observation of an operation; it returns nil if there is
+// no listener and all methods of a nil observation do nothing
+func (k *KVStore) observe(op Operation, keySrc []byte) *observation {
+	if k.Listener == nil {
+		return nil
+	}
+
+	o := &observation{
+		k:     k,
+		event: Event{Op: op, Key: keySrc},
+		start: time.Now(),
+	}
+	k.obs = o
+
+	return o
+}
+
+// set records the logical size and the number of parts of the operation
+func (o *observation) set(size, chunks int) {
+	if o == nil {
+		return
+	}
+	o.event.Size += size
+	o.event.Chunks += chunks
+}
+
+// deleted records a chunk of size bytes deleted from the store
+func (o *observation) deleted(size uint64) {
+	if o == nil {
+		return
+	}
+	o.event.BytesDeleted += int(size)
+}
+
+// done reports the operation to the listener; it must be deferred before
+// recoverError and trackOps, so it sees their results
+func (o *observation) done(err *error) {
+	if o == nil {
+		return
+	}
+	o.k.obs = nil
+
+	o.event.Ops = o.k.lastOps
+	o.event.Duration = time.Since(o.start)
+	o.event.Err = *err
+	o.k.Listener.OnOperation(o.event)
+}
@@ -0,0 +1,66 @@
+package storewrapper_test
+
+import (
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/stretchr/testify/require"
+)
+
+type recorder struct {
+	events []storewrapper.Event
+}
+
+func (r *recorder) OnOperation(e storewrapper.Event) {
+	r.events = append(r.events, e)
+}
+
+func TestKVStoreListener(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	r := &recorder{}
+	stw.WithListener(r)
+
+	key := []byte("Ezekiel")
+	parts, err := stw.SetW(key, []byte(sampleLarge))
+	require.Nil(t, err)
+	_, err = stw.GetW(key)
+	require.Nil(t, err)
+	_, err = stw.DeleteW(key)
+	require.Nil(t, err)
+	_, err = stw.WithMaxValueSize(10).SetW(key, []byte(sampleLarge))
+	require.NotNil(t, err)
+
+	require.Len(t, r.events, 4)
+
+	set := r.events[0]
+	require.Equal(t, storewrapper.OpSet, set.Op)
+	require.Equal(t, key, set.Key)
+	require.Equal(t, len(sampleLarge), set.Size)
+	require.Equal(t, parts, set.Chunks)
+	require.Equal(t, stw.EstimateSetW(len(sampleLarge), 0), set.Ops)
+	require.Nil(t, set.Err)
+
+	get := r.events[1]
+	require.Equal(t, storewrapper.OpGet, get.Op)
+	require.Equal(t, len(sampleLarge), get.Size)
+	require.Equal(t, parts, get.Chunks)
+
+	del := r.events[2]
+	require.Equal(t, storewrapper.OpDelete, del.Op)
+	require.Equal(t, parts, del.Chunks)
+	require.Equal(t, len(sampleLarge), del.BytesDeleted)
+
+	require.IsType(t, &storewrapper.ValueTooLargeError{}, r.events[3].Err)
+
+	// writes of a batch are reported once
+	stw.WithMaxValueSize(0)
+	_, err = stw.SetManyW(map[string][]byte{"a": []byte(sampleSmall), "b": []byte(sampleSmall)})
+	require.Nil(t, err)
+	require.Len(t, r.events, 5)
+	require.Equal(t, storewrapper.OpBatch, r.events[4].Op)
+	require.Equal(t, 2*len(sampleSmall), r.events[4].Size)
+	require.Equal(t, 4, r.events[4].Chunks)
+}
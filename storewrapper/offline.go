@@ -0,0 +1,132 @@
+package storewrapper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/cosmos-sdk/store"
+	"github.com/cosmos/cosmos-sdk/store/types"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// OpenLevelDB opens the latest version of the store storeName kept in the
+// application database name in dir, e.g. "application" in the data directory
+// of a stopped node, and returns a read-only wrapper over it, e.g. for CheckW,
+// and a function closing the database. Changing the state outside of the
+// state machine would change the app hash, so writes fail with ErrReadOnly.
+func OpenLevelDB(dir, name, storeName string) (*KVStore, func(), error) {
+	ms, key, closeDB, err := openLevelDB(dir, name, storeName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return NewKVStore(readOnlyStore{ms.GetKVStore(key)}, 0), closeDB, nil
+}
+
+// OpenLevelDBWritable opens the store like OpenLevelDB, but the wrapper
+// accepts writes, e.g. for CheckW(true). They are saved by commit as a new
+// version of the application state keeping the other stores unchanged;
+// closing the database without commit discards them. The app hash of the new
+// version doesn't match the chain, so a node can't continue from it: repair
+// a copy of the data directory, e.g. to export the state for a new genesis.
+func OpenLevelDBWritable(dir, name, storeName string) (stw *KVStore, commit func() types.CommitID, closeDB func(), err error) {
+	ms, key, closeDB, err := openLevelDB(dir, name, storeName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return NewKVStore(ms.GetKVStore(key), 0), ms.Commit, closeDB, nil
+}
+
+// openLevelDB loads the latest version of the application database with
+// every store it has and storeName
+func openLevelDB(dir, name, storeName string) (types.CommitMultiStore, types.StoreKey, func(), error) {
+	if _, err := os.Stat(filepath.Join(dir, name+".db")); err != nil {
+		return nil, nil, nil, err
+	}
+
+	db, err := sdk.NewLevelDB(name, dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	names, err := storeNames(db)
+	if err != nil {
+		db.Close()
+		return nil, nil, nil, err
+	}
+
+	ms := store.NewCommitMultiStore(db)
+	ms.SetPruning(types.PruneNothing)
+	key := sdk.NewKVStoreKey(storeName)
+	ms.MountStoreWithDB(key, sdk.StoreTypeIAVL, nil)
+	for _, n := range names {
+		if n != storeName {
+			ms.MountStoreWithDB(sdk.NewKVStoreKey(n), sdk.StoreTypeIAVL, nil)
+		}
+	}
+	if err := ms.LoadLatestVersion(); err != nil {
+		db.Close()
+		return nil, nil, nil, err
+	}
+
+	return ms, key, db.Close, nil
+}
+
+// commitInfo mirrors the record the root multistore keeps for every version
+type commitInfo struct {
+	Version    int64
+	StoreInfos []struct {
+		Name string
+		Core struct {
+			CommitID types.CommitID
+		}
+	}
+}
+
+// storeNames returns the names of the stores of the latest version of the
+// application database
+func storeNames(db interface{ Get([]byte) []byte }) ([]string, error) {
+	cdc := codec.New()
+
+	var version int64
+	if bz := db.Get([]byte("s/latest")); bz != nil {
+		if err := cdc.UnmarshalBinaryLengthPrefixed(bz, &version); err != nil {
+			return nil, err
+		}
+	}
+	if version == 0 {
+		return nil, nil
+	}
+
+	var info commitInfo
+	bz := db.Get([]byte(fmt.Sprintf("s/%d", version)))
+	if bz == nil {
+		return nil, fmt.Errorf("no commit info of version %d", version)
+	}
+	if err := cdc.UnmarshalBinaryLengthPrefixed(bz, &info); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(info.StoreInfos))
+	for i, si := range info.StoreInfos {
+		names[i] = si.Name
+	}
+
+	return names, nil
+}
+
+// readOnlyStore rejects writes to the underlying store
+type readOnlyStore struct {
+	types.KVStore
+}
+
+func (readOnlyStore) Set(key, value []byte) {
+	panic(ErrReadOnly)
+}
+
+func (readOnlyStore) Delete(key []byte) {
+	panic(ErrReadOnly)
+}
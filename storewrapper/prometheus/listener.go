@@ -0,0 +1,102 @@
+// Package prometheus exports the operations of storewrapper as Prometheus
+// metrics. It is kept apart from storewrapper, so only the applications
+// exporting metrics depend on Prometheus.
+package prometheus
+
+import (
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	_ storewrapper.Listener = (*Listener)(nil)
+	_ prometheus.Collector  = (*Listener)(nil)
+)
+
+// Listener is a storewrapper.Listener exporting the operations of the
+// wrapper as Prometheus metrics labelled by operation; it has to be
+// registered, e.g. with prometheus.MustRegister. Keys aren't used as labels.
+type Listener struct {
+	ops          *prometheus.CounterVec
+	errors       *prometheus.CounterVec
+	chunks       *prometheus.CounterVec
+	bytesRead    *prometheus.CounterVec
+	bytesWritten *prometheus.CounterVec
+	bytesDeleted *prometheus.CounterVec
+	valueSize    *prometheus.HistogramVec
+	duration     *prometheus.HistogramVec
+}
+
+// NewListener returns a listener with metrics named namespace_subsystem_*
+func NewListener(namespace, subsystem string) *Listener {
+	counter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      name,
+			Help:      help,
+		}, []string{"op"})
+	}
+
+	return &Listener{
+		ops:          counter("operations_total", "Number of operations."),
+		errors:       counter("errors_total", "Number of operations that failed."),
+		chunks:       counter("chunks_total", "Number of chunks read, written or deleted."),
+		bytesRead:    counter("read_bytes_total", "Bytes read from the underlying store."),
+		bytesWritten: counter("written_bytes_total", "Bytes written to the underlying store."),
+		bytesDeleted: counter("deleted_bytes_total", "Bytes of chunks deleted from the underlying store."),
+		valueSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "value_size_bytes",
+			Help:      "Logical size of the values of an operation.",
+			Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+		}, []string{"op"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "duration_seconds",
+			Help:      "Duration of an operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{"op"}),
+	}
+}
+
+// OnOperation implements storewrapper.Listener
+func (l *Listener) OnOperation(e storewrapper.Event) {
+	op := string(e.Op)
+
+	l.ops.WithLabelValues(op).Inc()
+	if e.Err != nil {
+		l.errors.WithLabelValues(op).Inc()
+		return
+	}
+
+	l.chunks.WithLabelValues(op).Add(float64(e.Chunks))
+	l.bytesRead.WithLabelValues(op).Add(float64(e.Ops.BytesRead))
+	l.bytesWritten.WithLabelValues(op).Add(float64(e.Ops.BytesWritten))
+	l.bytesDeleted.WithLabelValues(op).Add(float64(e.BytesDeleted))
+	l.valueSize.WithLabelValues(op).Observe(float64(e.Size))
+	l.duration.WithLabelValues(op).Observe(e.Duration.Seconds())
+}
+
+// Describe implements prometheus.Collector
+func (l *Listener) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range l.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (l *Listener) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range l.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (l *Listener) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		l.ops, l.errors, l.chunks, l.bytesRead, l.bytesWritten, l.bytesDeleted,
+		l.valueSize, l.duration,
+	}
+}
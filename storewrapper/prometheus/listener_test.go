@@ -0,0 +1,47 @@
+package prometheus_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	swprometheus "github.com/corestario/cosmos-utils/storewrapper/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListener(t *testing.T) {
+	l := swprometheus.NewListener("app", "storewrapper")
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(l)
+
+	l.OnOperation(storewrapper.Event{
+		Op:       storewrapper.OpSet,
+		Size:     1000,
+		Chunks:   3,
+		Ops:      storewrapper.Ops{Set: 4, BytesWritten: 1100},
+		Duration: time.Millisecond,
+	})
+	l.OnOperation(storewrapper.Event{Op: storewrapper.OpGet, Size: 1000, Chunks: 3})
+	l.OnOperation(storewrapper.Event{Op: storewrapper.OpGet, Err: errors.New("broken")})
+
+	families, err := registry.Gather()
+	require.Nil(t, err)
+
+	values := func(name string) map[string]float64 {
+		res := map[string]float64{}
+		for _, f := range families {
+			if f.GetName() != name {
+				continue
+			}
+			for _, m := range f.GetMetric() {
+				res[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+			}
+		}
+		return res
+	}
+	require.Equal(t, map[string]float64{"set": 1, "get": 2}, values("app_storewrapper_operations_total"))
+	require.Equal(t, map[string]float64{"get": 1}, values("app_storewrapper_errors_total"))
+	require.Equal(t, map[string]float64{"set": 1100, "get": 0}, values("app_storewrapper_written_bytes_total"))
+}
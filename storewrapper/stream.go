@@ -0,0 +1,345 @@
+package storewrapper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+)
+
+var (
+	_ io.WriteCloser = (*ValueWriter)(nil)
+	_ io.ReadSeeker  = (*ValueReader)(nil)
+	_ io.ReaderAt    = (*ValueReader)(nil)
+)
+
+// ValueWriter writes a value chunk by chunk as data arrives. The previous
+// value under the key is replaced on Close and stays readable until then.
+// If the value is replaced meanwhile, the writer fails with ErrWriteConflict
+// without writing more chunks. Streaming doesn't support compression and
+// encryption.
+type ValueWriter struct {
+	k       *KVStore
+	key     []byte
+	m       manifest
+	buf     []byte
+	hash    hash.Hash
+	old     manifest
+	started bool
+	closed  bool
+	err     error
+}
+
+// NewValueWriter returns a writer replacing the value stored under keySrc
+func (k *KVStore) NewValueWriter(keySrc []byte) *ValueWriter {
+	return &ValueWriter{
+		k:   k,
+		key: append([]byte{}, keySrc...),
+		m: manifest{
+			Version:   ManifestVersion,
+			ChunkSize: uint64(k.chunkSize()),
+		},
+		hash: sha256.New(),
+	}
+}
+
+// Write stores every complete chunk of p; the remainder is kept until the
+// next Write or Close
+func (w *ValueWriter) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+	defer func() { w.err = err }()
+	defer recoverError(&err)
+
+	if w.started && len(w.buf)+len(p) >= int(w.m.ChunkSize) {
+		if err = w.checkConflict(); err != nil {
+			return 0, err
+		}
+	}
+	if err = w.start(); err != nil {
+		return 0, err
+	}
+	if err = w.k.ValidateSize(int(w.m.Length) + len(w.buf) + len(p)); err != nil {
+		return 0, err
+	}
+
+	chunkSize := int(w.m.ChunkSize)
+	for len(p) > 0 {
+		take := chunkSize - len(w.buf)
+		if take > len(p) {
+			take = len(p)
+		}
+
+		w.buf = append(w.buf, p[:take]...)
+		p = p[take:]
+		n += take
+
+		if len(w.buf) == chunkSize {
+			w.flush()
+		}
+	}
+
+	return n, nil
+}
+
+// Close stores the last chunk and the manifest and deletes stale chunks of
+// the previous value
+func (w *ValueWriter) Close() (err error) {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return nil
+	}
+	o := w.k.observe(OpStream, w.key)
+	defer o.done(&err)
+	defer func() { w.err = err }()
+	defer recoverError(&err)
+
+	if w.started {
+		if err = w.checkConflict(); err != nil {
+			return err
+		}
+	}
+	if err = w.start(); err != nil {
+		return err
+	}
+	if len(w.buf) > 0 {
+		w.flush()
+	}
+
+	w.m.Hash = w.hash.Sum(nil)
+	w.k.commit(w.key, w.old, w.m)
+	w.closed = true
+	o.set(int(w.m.Length), int(w.m.Chunks))
+
+	return nil
+}
+
+func (w *ValueWriter) start() (err error) {
+	if w.started {
+		return nil
+	}
+	if w.k.Compressor != nil {
+		return ErrCompressedStream
+	}
+	if w.k.Cipher != nil {
+		return ErrEncryptedStream
+	}
+
+	if err = w.k.ValidateChunkSize(); err != nil {
+		return err
+	}
+	if err = w.k.validateDedup(); err != nil {
+		return err
+	}
+
+	w.old, err = w.k.current(w.key)
+	w.m.Generation = w.old.nextGeneration()
+	w.m.Dedup = w.k.Dedup
+	w.buf = make([]byte, 0, w.m.ChunkSize)
+	w.started = true
+
+	return err
+}
+
+// checkConflict returns ErrWriteConflict if the value was replaced since the
+// writer started: a write picks the same generation, so the chunks of the
+// writer can't be written or committed any more
+func (w *ValueWriter) checkConflict() error {
+	m, ok, err := w.k.getManifest(w.key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		m = manifest{}
+	}
+	if m.Generation != w.old.Generation {
+		return ErrWriteConflict
+	}
+
+	return nil
+}
+
+func (w *ValueWriter) flush() {
+	w.hash.Write(w.buf)
+	if w.k.ChunkHashes || w.m.Dedup {
+		w.m.ChunkHashes = append(w.m.ChunkHashes, hashOf(w.buf))
+	}
+	w.k.writeChunk(w.key, w.m, w.m.Chunks, w.buf)
+
+	w.m.Chunks++
+	w.m.Length += uint64(len(w.buf))
+	// the store may keep a reference to the written chunk
+	w.buf = make([]byte, 0, w.m.ChunkSize)
+}
+
+// ValueReader reads a stored value loading only the chunks it needs. Chunk
+// checksums are verified as chunks are loaded; the checksum of the whole
+// value is verified once every chunk has been loaded in order.
+type ValueReader struct {
+	k      *KVStore
+	key    []byte
+	m      manifest
+	offset int64
+
+	chunk    []byte
+	chunkIdx uint64
+	loaded   bool
+
+	hash   hash.Hash
+	hashed uint64
+}
+
+// NewValueReader returns a reader of the value stored under keySrc
+func (k *KVStore) NewValueReader(keySrc []byte) (r *ValueReader, err error) {
+	defer recoverError(&err)
+
+	m, ok, err := k.getManifest(keySrc)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if m, ok = k.legacyManifest(keySrc); !ok {
+			return nil, ErrNotFound
+		}
+	}
+	if m.Codec != "" {
+		return nil, ErrCompressedStream
+	}
+	if len(m.Nonce) > 0 {
+		return nil, ErrEncryptedStream
+	}
+
+	return &ValueReader{
+		k:    k,
+		key:  append([]byte{}, keySrc...),
+		m:    m,
+		hash: sha256.New(),
+	}, nil
+}
+
+// GetRangeW returns up to length bytes of the value starting at offset,
+// reading only the chunks covering the range. Negative offset or length is
+// an ErrInvalidRange.
+func (k *KVStore) GetRangeW(keySrc []byte, offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, ErrInvalidRange
+	}
+
+	r, err := k.NewValueReader(keySrc)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset >= r.Size() {
+		return []byte{}, nil
+	}
+	if length > r.Size()-offset {
+		length = r.Size() - offset
+	}
+
+	res := make([]byte, length)
+	n, err := r.ReadAt(res, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return res[:n], nil
+}
+
+// Size returns the length of the value
+func (r *ValueReader) Size() int64 {
+	return int64(r.m.Length)
+}
+
+// Read implements io.Reader
+func (r *ValueReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt
+func (r *ValueReader) ReadAt(p []byte, off int64) (n int, err error) {
+	defer recoverError(&err)
+
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	chunkSize := int64(r.m.ChunkSize)
+	for n < len(p) && off < r.Size() {
+		i := uint64(off / chunkSize)
+		if err = r.load(i); err != nil {
+			return n, err
+		}
+
+		c := copy(p[n:], r.chunk[off%chunkSize:])
+		n += c
+		off += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Seek implements io.Seeker
+func (r *ValueReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.Size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+
+	return offset, nil
+}
+
+// load makes chunk i the current one
+func (r *ValueReader) load(i uint64) error {
+	if r.loaded && r.chunkIdx == i {
+		return nil
+	}
+
+	ba := r.k.Get(r.k.chunkKey(r.key, r.m, i))
+	if err := r.m.verifyChunk(r.key, i, ba); err != nil {
+		return err
+	}
+
+	if expected := r.m.chunkLen(i); uint64(len(ba)) != expected {
+		return errCorrupted(r.key, int(i), "length %d, expected %d", len(ba), expected)
+	}
+
+	if i == r.hashed && len(r.m.Hash) > 0 {
+		r.hash.Write(ba)
+		r.hashed++
+		if r.hashed == r.m.Chunks && !bytes.Equal(r.hash.Sum(nil), r.m.Hash) {
+			return errCorrupted(r.key, -1, "checksum mismatch")
+		}
+	}
+
+	r.chunk, r.chunkIdx, r.loaded = ba, i, true
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package storewrapper_test
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStoreStreaming(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	key := []byte("Ezekiel")
+	_, err = stw.SetW(key, []byte(sampleLarge+sampleLarge))
+	require.Nil(t, err)
+
+	w := stw.WithChunkHashes(true).NewValueWriter(key)
+	value := []byte(sampleLarge)
+	for i := 0; i < len(value); i += 37 {
+		end := i + 37
+		if end > len(value) {
+			end = len(value)
+		}
+		n, err := w.Write(value[i:end])
+		require.Nil(t, err)
+		require.Equal(t, end-i, n)
+	}
+	require.Nil(t, w.Close())
+
+	res, err := stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+	parts, _, err := stw.HasW(key)
+	require.Nil(t, err)
+	require.Equal(t, (len(sampleLarge)+99)/100, parts)
+
+	r, err := stw.NewValueReader(key)
+	require.Nil(t, err)
+	res, err = ioutil.ReadAll(r)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+
+	pos, err := r.Seek(-50, io.SeekEnd)
+	require.Nil(t, err)
+	require.Equal(t, int64(len(sampleLarge)-50), pos)
+	res, err = ioutil.ReadAll(r)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge[len(sampleLarge)-50:], string(res))
+
+	res, err = stw.GetRangeW(key, 250, 120)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge[250:370], string(res))
+
+	res, err = stw.GetRangeW(key, int64(len(sampleLarge)-10), math.MaxInt64)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge[len(sampleLarge)-10:], string(res))
+
+	_, err = stw.GetRangeW(key, -1, 10)
+	require.Equal(t, storewrapper.ErrInvalidRange, err)
+	_, err = stw.GetRangeW(key, 10, -1)
+	require.Equal(t, storewrapper.ErrInvalidRange, err)
+
+	_, err = stw.NewValueReader([]byte("missing"))
+	require.Equal(t, storewrapper.ErrNotFound, err)
+
+	_, err = stw.WithCompressor(storewrapper.GzipCompressor).NewValueWriter(key).Write(value)
+	require.Equal(t, storewrapper.ErrCompressedStream, err)
+}
+
+func TestKVStoreStreamingConflict(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = storewrapper.MinChunkSize
+
+	key := []byte("Ezekiel")
+	_, err = stw.SetW(key, []byte(sampleLarge))
+	require.Nil(t, err)
+
+	// the value is replaced after the writer started
+	w := stw.NewValueWriter(key)
+	_, err = w.Write([]byte(sampleLarge[:storewrapper.MinChunkSize+10]))
+	require.Nil(t, err)
+	value := []byte(sampleLarge + sampleLarge)
+	_, err = stw.SetW(key, value)
+	require.Nil(t, err)
+
+	_, err = w.Write([]byte(sampleLarge))
+	require.Equal(t, storewrapper.ErrWriteConflict, err)
+	require.Equal(t, storewrapper.ErrWriteConflict, w.Close())
+
+	// the replacing value isn't overwritten
+	w = stw.NewValueWriter(key)
+	_, err = w.Write([]byte(sampleSmall))
+	require.Nil(t, err)
+	_, err = stw.SetW(key, value)
+	require.Nil(t, err)
+	require.Equal(t, storewrapper.ErrWriteConflict, w.Close())
+
+	res, err := stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, value, res)
+
+	// so is a value written where there was none
+	w = stw.NewValueWriter([]byte("new"))
+	_, err = w.Write([]byte(sampleSmall))
+	require.Nil(t, err)
+	_, err = stw.SetW([]byte("new"), value)
+	require.Nil(t, err)
+	require.Equal(t, storewrapper.ErrWriteConflict, w.Close())
+}
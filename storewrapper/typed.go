@@ -0,0 +1,72 @@
+package storewrapper
+
+import (
+	"github.com/cosmos/cosmos-sdk/codec"
+)
+
+// TypedStore encodes objects with an amino codec and stores them through the
+// wrapper. GetObject distinguishes missing values (ErrNotFound), values that
+// fail checksum verification (*CorruptionError) and values that can't be
+// decoded (*DecodeError).
+type TypedStore struct {
+	*KVStore
+	Codec *codec.Codec
+	JSON  bool
+}
+
+// NewTypedStore returns a typed store using binary amino encoding
+func NewTypedStore(store *KVStore, cdc *codec.Codec) *TypedStore {
+	return &TypedStore{
+		KVStore: store,
+		Codec:   cdc,
+	}
+}
+
+// WithJSON returns the store using amino JSON instead of binary encoding
+func (s *TypedStore) WithJSON(json bool) *TypedStore {
+	s.JSON = json
+	return s
+}
+
+// GetObject decodes the value stored under key into ptr
+func (s *TypedStore) GetObject(key []byte, ptr interface{}) (err error) {
+	o := s.observe(OpGet, key)
+	defer o.done(&err)
+	defer recoverError(&err)
+	defer s.trackOps()()
+
+	bz, parts, ok, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	o.set(len(bz), parts)
+	if !ok {
+		return ErrNotFound
+	}
+
+	if s.JSON {
+		err = s.Codec.UnmarshalJSON(bz, ptr)
+	} else {
+		err = s.Codec.UnmarshalBinaryLengthPrefixed(bz, ptr)
+	}
+	if err != nil {
+		return &DecodeError{Key: key, Err: err}
+	}
+
+	return nil
+}
+
+// SetObject encodes obj and stores it under key; return parts stored, error
+func (s *TypedStore) SetObject(key []byte, obj interface{}) (parts int, err error) {
+	var bz []byte
+	if s.JSON {
+		bz, err = s.Codec.MarshalJSON(obj)
+	} else {
+		bz, err = s.Codec.MarshalBinaryLengthPrefixed(obj)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return s.SetW(key, bz)
+}
@@ -0,0 +1,46 @@
+package storewrapper_test
+
+import (
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/stretchr/testify/require"
+)
+
+type testRecord struct {
+	Name  string
+	Verse []string
+	Count uint64
+}
+
+func TestTypedStore(t *testing.T) {
+	for _, json := range []bool{false, true} {
+		stw, err := makeTestStore()
+		require.Nil(t, err)
+		stw.ChunkSize = 100
+
+		ts := storewrapper.NewTypedStore(stw, codec.New()).WithJSON(json)
+		key := []byte("Ezekiel")
+		rec := testRecord{Name: "Ezekiel", Verse: []string{sampleLarge, sampleSmall}, Count: 25}
+
+		parts, err := ts.SetObject(key, rec)
+		require.Nil(t, err)
+		require.True(t, parts > 1)
+
+		var res testRecord
+		require.Nil(t, ts.GetObject(key, &res))
+		require.Equal(t, rec, res)
+
+		require.Equal(t, storewrapper.ErrNotFound, ts.GetObject([]byte("missing"), &res))
+
+		_, err = ts.SetW(key, []byte{0xFF, 0xFF})
+		require.Nil(t, err)
+		require.IsType(t, &storewrapper.DecodeError{}, ts.GetObject(key, &res))
+
+		_, err = ts.SetObject(key, rec)
+		require.Nil(t, err)
+		ts.Delete(rawChunkKey(key, 3, 1))
+		require.True(t, storewrapper.IsCorruption(ts.GetObject(key, &res)))
+	}
+}
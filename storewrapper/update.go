@@ -0,0 +1,188 @@
+package storewrapper
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// AppendW appends data to the value stored under keySrc, rewriting only its
+// last chunk if it isn't full; return parts written, error. A missing value
+// is created. Existing values keep the chunk size they were written with,
+// except values without a manifest whose chunk size isn't known or is below
+// MinChunkSize, which are rewritten in full with the chunk size of the
+// wrapper.
+func (k *KVStore) AppendW(keySrc, data []byte) (parts int, err error) {
+	o := k.observe(OpAppend, keySrc)
+	defer o.done(&err)
+	defer recoverError(&err)
+	defer k.trackOps()()
+
+	old, err := k.updated(keySrc)
+	if err != nil {
+		return 0, err
+	}
+
+	return k.writeAt(o, keySrc, old, old.Length, data)
+}
+
+// WriteAtW overwrites the value stored under keySrc with data starting at
+// offset, rewriting only the chunks it covers; return parts written, error.
+// The value grows if data goes past its end; offset can't be past the end.
+//
+// Unchanged chunks are kept as they are, but they are read to check the old
+// value and to compute the checksum of the new one. Values keeping versions
+// and values switching to or from deduplication are copied in full.
+func (k *KVStore) WriteAtW(keySrc []byte, offset int64, data []byte) (parts int, err error) {
+	o := k.observe(OpWriteAt, keySrc)
+	defer o.done(&err)
+	defer recoverError(&err)
+	defer k.trackOps()()
+
+	old, err := k.updated(keySrc)
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 || uint64(offset) > old.Length {
+		return 0, ErrInvalidOffset
+	}
+
+	return k.writeAt(o, keySrc, old, uint64(offset), data)
+}
+
+// updated returns the manifest of a value about to be updated in place
+func (k *KVStore) updated(keySrc []byte) (manifest, error) {
+	if k.Compressor != nil || k.Cipher != nil {
+		return manifest{}, ErrPartialUpdate
+	}
+	if err := k.validateDedup(); err != nil {
+		return manifest{}, err
+	}
+
+	m, ok, err := k.getManifest(keySrc)
+	if err != nil {
+		return m, err
+	}
+	if !ok {
+		m, ok = k.legacyManifest(keySrc)
+	}
+	if !ok {
+		if err := k.ValidateChunkSize(); err != nil {
+			return m, err
+		}
+		m.ChunkSize = uint64(k.chunkSize())
+	}
+	if m.Codec != "" || len(m.Nonce) > 0 {
+		return m, ErrPartialUpdate
+	}
+
+	return m, nil
+}
+
+// writeAt writes data at offset of the value described by old for the
+// operation observed by o. Chunks overlapping data are written under a new
+// generation, the others are kept unless the whole value has to be copied.
+// Every old chunk is read if the old value has a checksum, so corruption
+// isn't carried over into the checksum of the new value.
+func (k *KVStore) writeAt(o *observation, keySrc []byte, old manifest, offset uint64, data []byte) (int, error) {
+	length := old.Length
+	if end := offset + uint64(len(data)); end > length {
+		length = end
+	}
+	if err := k.ValidateSize(int(length)); err != nil {
+		return 0, err
+	}
+
+	cs := old.ChunkSize
+	var oldValue []byte
+	if old.Version == 0 && old.Chunks > 0 && (old.Chunks == 1 || cs < MinChunkSize) {
+		// the chunk size of a single chunk is its length
+		if err := k.ValidateChunkSize(); err != nil {
+			return 0, err
+		}
+		oldValue, _ = k.getLegacy(keySrc)
+		cs = uint64(k.chunkSize())
+	}
+
+	m := manifest{
+		Version:    ManifestVersion,
+		Length:     length,
+		Chunks:     (length + cs - 1) / cs,
+		ChunkSize:  cs,
+		Dedup:      k.Dedup,
+		Generation: old.nextGeneration(),
+	}
+	withHashes := k.ChunkHashes || k.Dedup || len(old.ChunkHashes) > 0
+	if withHashes {
+		m.ChunkHashes = make([][]byte, m.Chunks)
+	}
+	m.ChunkGenerations = make([]uint32, m.Chunks)
+
+	copyAll := oldValue != nil || k.keepsVersions(old) || old.Dedup != k.Dedup
+	verifyOld := len(old.Hash) > 0
+	hash, oldHash := sha256.New(), sha256.New()
+	parts := 0
+	for i := uint64(0); i < m.Chunks; i++ {
+		start, end := i*cs, (i+1)*cs
+		if end > length {
+			end = length
+		}
+		changed := start < offset+uint64(len(data)) && offset < end
+
+		var ba []byte
+		if oldValue != nil {
+			if start < uint64(len(oldValue)) {
+				ba = oldValue[start:]
+			}
+		} else if i < old.Chunks && (verifyOld || !changed || start < offset || offset+uint64(len(data)) < end) {
+			ba = k.Get(k.chunkKey(keySrc, old, i))
+			if err := old.verifyChunk(keySrc, i, ba); err != nil {
+				return parts, err
+			}
+			oldHash.Write(ba)
+		}
+
+		if !changed && !copyAll {
+			m.ChunkGenerations[i] = old.generation(i)
+			if withHashes && len(old.ChunkHashes) > 0 {
+				m.ChunkHashes[i] = old.ChunkHashes[i]
+			}
+			hash.Write(ba)
+			continue
+		}
+
+		chunk := make([]byte, end-start)
+		copy(chunk, ba)
+		if changed {
+			copyFrom(chunk, start, data, offset)
+		}
+
+		m.ChunkGenerations[i] = m.Generation
+		if withHashes {
+			m.ChunkHashes[i] = hashOf(chunk)
+		}
+		hash.Write(chunk)
+		k.writeChunk(keySrc, m, i, chunk)
+		parts++
+	}
+
+	if verifyOld && !bytes.Equal(oldHash.Sum(nil), old.Hash) {
+		return parts, errCorrupted(keySrc, -1, "checksum mismatch")
+	}
+	m.Hash = hash.Sum(nil)
+	m.compactGenerations()
+
+	k.commit(keySrc, old, m)
+	o.set(len(data), parts)
+
+	return parts, nil
+}
+
+// copyFrom copies the part of data written at offset that falls into chunk,
+// which starts at start
+func copyFrom(chunk []byte, start uint64, data []byte, offset uint64) {
+	if offset >= start {
+		copy(chunk[offset-start:], data)
+	} else {
+		copy(chunk, data[start-offset:])
+	}
+}
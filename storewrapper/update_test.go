@@ -0,0 +1,195 @@
+package storewrapper_test
+
+import (
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStoreAppend(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.WithNamespace([]byte("ns/")).ChunkSize = 100
+
+	key := []byte("Ezekiel")
+	parts, err := stw.AppendW(key, []byte(sampleLarge[:150]))
+	require.Nil(t, err)
+	require.Equal(t, 2, parts)
+
+	// only the last chunk is rewritten
+	parts, err = stw.AppendW(key, []byte(sampleLarge[150:420]))
+	require.Nil(t, err)
+	require.Equal(t, 4, parts)
+	require.Equal(t, parts+1, stw.LastOps().Set)
+
+	res, err := stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge[:420], string(res))
+	// manifest and 5 chunks, no stale ones
+	require.Equal(t, 6, countRaw(stw))
+
+	parts, err = stw.WriteAtW(key, 190, []byte("Jules"))
+	require.Nil(t, err)
+	require.Equal(t, 1, parts)
+	parts, err = stw.WriteAtW(key, 395, []byte(sampleSmall))
+	require.Nil(t, err)
+	require.Equal(t, 3, parts)
+	require.Equal(t, 7, countRaw(stw))
+
+	expected := sampleLarge[:190] + "Jules" + sampleLarge[195:395] + sampleSmall
+	res, err = stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, expected, string(res))
+	res, err = stw.GetRangeW(key, 180, 30)
+	require.Nil(t, err)
+	require.Equal(t, expected[180:210], string(res))
+
+	_, err = stw.WriteAtW(key, int64(len(expected)+1), []byte("Jules"))
+	require.Equal(t, storewrapper.ErrInvalidOffset, err)
+	_, err = stw.WithCompressor(storewrapper.GzipCompressor).AppendW(key, []byte("Jules"))
+	require.Equal(t, storewrapper.ErrPartialUpdate, err)
+
+	_, err = stw.WithCompressor(nil).DeleteW(key)
+	require.Nil(t, err)
+	require.Equal(t, 0, countRaw(stw))
+}
+
+func TestKVStoreAppendChecksums(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	key := []byte("Ezekiel")
+	_, err = stw.SetW(key, []byte(sampleLarge[:250]))
+	require.Nil(t, err)
+
+	// chunks kept without a checksum are still read
+	_, err = stw.WithChunkHashes(true).AppendW(key, []byte(sampleLarge[250:]))
+	require.Nil(t, err)
+	res, err := stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+
+	// rewritten chunks are checked
+	stw.Set(rawChunkKey(key, 2, 3), []byte(sampleLarge[:100]))
+	_, err = stw.GetW(key)
+	require.True(t, storewrapper.IsCorruption(err))
+}
+
+func TestKVStoreUpdateCorruption(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	key := []byte("Ezekiel")
+	_, err = stw.SetW(key, []byte(sampleLarge[:250]))
+	require.Nil(t, err)
+	_, err = stw.WriteAtW(key, 120, []byte("Jules"))
+	require.Nil(t, err)
+
+	// the checksum of the whole value covers the chunks kept by the update
+	stw.Set(rawChunkKey(key, 1, 0), []byte(sampleLarge[100:200]))
+	_, err = stw.GetW(key)
+	require.True(t, storewrapper.IsCorruption(err))
+
+	// and an update doesn't take over a corrupted chunk
+	_, err = stw.AppendW(key, []byte(sampleSmall))
+	require.True(t, storewrapper.IsCorruption(err))
+}
+
+func TestKVStoreAppendVersions(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		stw, err := makeTestStore()
+		require.Nil(t, err)
+		stw.WithNamespace([]byte("ns/")).WithDedup(dedup).WithVersions(0).ChunkSize = 100
+
+		key := []byte("Ezekiel")
+		_, err = stw.SetW(key, []byte(sampleLarge[:250]))
+		require.Nil(t, err)
+		_, err = stw.AppendW(key, []byte(sampleLarge[250:]))
+		require.Nil(t, err)
+
+		res, err := stw.GetVersionW(key, 1)
+		require.Nil(t, err)
+		require.Equal(t, sampleLarge[:250], string(res))
+		res, err = stw.GetVersionW(key, 2)
+		require.Nil(t, err)
+		require.Equal(t, sampleLarge, string(res))
+
+		// pruning the old version doesn't touch chunks of the new one
+		_, err = stw.PruneVersionsW(key, 100)
+		require.Nil(t, err)
+		res, err = stw.GetW(key)
+		require.Nil(t, err)
+		require.Equal(t, sampleLarge, string(res))
+
+		_, err = stw.DeleteW(key)
+		require.Nil(t, err)
+		require.Equal(t, 0, countRaw(stw))
+	}
+}
+
+func TestKVStoreAppendDedup(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.WithNamespace([]byte("ns/")).WithDedup(true).ChunkSize = 100
+
+	_, err = stw.SetW([]byte("Ezekiel"), []byte(sampleLarge[:250]))
+	require.Nil(t, err)
+	_, err = stw.SetW([]byte("Jules"), []byte(sampleLarge[:250]))
+	require.Nil(t, err)
+
+	_, err = stw.AppendW([]byte("Ezekiel"), []byte(sampleLarge[250:]))
+	require.Nil(t, err)
+	_, err = stw.WriteAtW([]byte("Jules"), 0, []byte("Jules"))
+	require.Nil(t, err)
+
+	res, err := stw.GetW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	require.Equal(t, sampleLarge, string(res))
+	res, err = stw.GetW([]byte("Jules"))
+	require.Nil(t, err)
+	require.Equal(t, "Jules"+sampleLarge[5:250], string(res))
+
+	_, err = stw.DeleteW([]byte("Ezekiel"))
+	require.Nil(t, err)
+	_, err = stw.DeleteW([]byte("Jules"))
+	require.Nil(t, err)
+	require.Equal(t, 0, countRaw(stw))
+}
+
+func TestKVStoreAppendLegacy(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	stw.ChunkSize = 100
+
+	// the chunk size of a single chunk isn't known, so the wrapper's is used
+	key := []byte("legacy")
+	stw.Set(rawChunkKey(key, 0, 0), []byte("Jule"))
+	parts, err := stw.AppendW(key, []byte(sampleLarge[:4096]))
+	require.Nil(t, err)
+	require.Equal(t, 41, parts)
+	// manifest and 41 chunks, no legacy one
+	require.Equal(t, 42, countRaw(stw))
+
+	res, err := stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, "Jule"+sampleLarge[:4096], string(res))
+
+	// so is it for chunks smaller than MinChunkSize
+	key = []byte("small")
+	for i, chunk := range []string{"Jule", "s Wi", "nn"} {
+		stw.Set(rawChunkKey(key, 0, byte(i)), []byte(chunk))
+	}
+	parts, err = stw.WriteAtW(key, 2, []byte("xx"))
+	require.Nil(t, err)
+	require.Equal(t, 1, parts)
+
+	res, err = stw.GetW(key)
+	require.Nil(t, err)
+	require.Equal(t, "Juxxs Winn", string(res))
+	for i := byte(0); i < 3; i++ {
+		require.False(t, stw.Has(rawChunkKey(key, 0, i)))
+	}
+}
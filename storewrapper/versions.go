@@ -0,0 +1,155 @@
+package storewrapper
+
+// WithVersions returns the wrapper keeping the previous value as an older
+// version on every write. PruneVersionsW deletes versions beyond the latest
+// retain ones; a negative retain is 0. Once a value has versions it keeps getting them even through
+// a wrapper without versioning.
+//
+// Generations of chunks wrap around after 2^32-1 writes of a key, so a value
+// shouldn't keep versions for that many writes.
+func (k *KVStore) WithVersions(retain int) *KVStore {
+	if retain < 0 {
+		retain = 0
+	}
+	k.Versioned = true
+	k.RetainVersions = retain
+	return k
+}
+
+// GetVersionW returns the given version of a value
+func (k *KVStore) GetVersionW(keySrc []byte, version uint64) (res []byte, err error) {
+	o := k.observe(OpGetVersion, keySrc)
+	defer o.done(&err)
+	defer recoverError(&err)
+	defer k.trackOps()()
+
+	m, ok, err := k.getManifest(keySrc)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || version < m.OldestVersion || version > m.ValueVersion {
+		return nil, ErrVersionNotFound
+	}
+	if version != m.ValueVersion {
+		if m, err = k.getVersion(keySrc, version); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err = k.read(keySrc, m)
+	o.set(len(res), int(m.Chunks))
+
+	return res, err
+}
+
+// ListVersionsW returns the versions of a value from the oldest to the latest
+func (k *KVStore) ListVersionsW(keySrc []byte) (versions []uint64, err error) {
+	defer recoverError(&err)
+	defer k.trackOps()()
+
+	m, ok, err := k.getManifest(keySrc)
+	if err != nil || !ok || m.ValueVersion == 0 {
+		return nil, err
+	}
+
+	for v := m.OldestVersion; v <= m.ValueVersion; v++ {
+		versions = append(versions, v)
+	}
+
+	return versions, nil
+}
+
+// PruneVersionsW deletes the oldest versions of a value so that at most
+// RetainVersions older versions remain, none if it is negative. Versions are deleted as a whole and
+// only while the number of records deleted stays within maxDeletes, so it can
+// be called every block until it returns 0. Return versions pruned, error;
+// ErrPruneBudget if not even the oldest version fits into maxDeletes.
+func (k *KVStore) PruneVersionsW(keySrc []byte, maxDeletes int) (pruned int, err error) {
+	o := k.observe(OpPruneVersions, keySrc)
+	defer o.done(&err)
+	defer recoverError(&err)
+	defer k.trackOps()()
+
+	m, ok, err := k.getManifest(keySrc)
+	if err != nil || !ok || m.ValueVersion == 0 {
+		return 0, err
+	}
+
+	retain := uint64(0)
+	if k.RetainVersions > 0 {
+		retain = uint64(k.RetainVersions)
+	}
+
+	var (
+		prune   []manifest
+		deletes int
+	)
+	for v := m.OldestVersion; m.ValueVersion-v > retain; v++ {
+		old, err := k.getVersion(keySrc, v)
+		if err != nil {
+			return 0, err
+		}
+
+		if deletes+int(old.Chunks)+1 > maxDeletes {
+			break
+		}
+		deletes += int(old.Chunks) + 1
+		prune = append(prune, old)
+	}
+
+	if len(prune) == 0 {
+		if m.ValueVersion-m.OldestVersion > retain {
+			return 0, ErrPruneBudget
+		}
+		return 0, nil
+	}
+
+	// hide the versions first, so a failure leaves unreachable records only
+	first := m.OldestVersion
+	m.OldestVersion += uint64(len(prune))
+	k.Set(k.keys().manifestKey(keySrc), m.bytes())
+
+	for i, old := range prune {
+		k.Delete(k.keys().versionKey(keySrc, first+uint64(i)))
+		k.deleteChunks(keySrc, old)
+		o.set(0, int(old.Chunks))
+	}
+
+	return len(prune), nil
+}
+
+// getVersion returns the manifest of an older version
+func (k *KVStore) getVersion(keySrc []byte, version uint64) (manifest, error) {
+	bz := k.Get(k.keys().versionKey(keySrc, version))
+	if bz == nil {
+		return manifest{}, errCorrupted(keySrc, -1, "version %d is missing", version)
+	}
+
+	m, err := decodeManifest(bz)
+	if err != nil {
+		return m, errCorrupted(keySrc, -1, "bad manifest of version %d: %v", version, err)
+	}
+
+	return m, nil
+}
+
+// deleteVersions deletes all older versions of the value described by m
+func (k *KVStore) deleteVersions(keySrc []byte, m manifest) error {
+	for v := m.OldestVersion; v < m.ValueVersion; v++ {
+		old, err := k.getVersion(keySrc, v)
+		if err != nil {
+			return err
+		}
+
+		k.Delete(k.keys().versionKey(keySrc, v))
+		k.deleteChunks(keySrc, old)
+	}
+
+	return nil
+}
+
+// keepsVersions reports whether replacing the value described by old keeps
+// it as a version
+func (k *KVStore) keepsVersions(old manifest) bool {
+	return k.Versioned || old.ValueVersion > 0
+}
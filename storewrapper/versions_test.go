@@ -0,0 +1,93 @@
+package storewrapper_test
+
+import (
+	"testing"
+
+	"github.com/corestario/cosmos-utils/storewrapper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStoreVersions(t *testing.T) {
+	for _, ns := range [][]byte{nil, []byte("ns/")} {
+		stw, err := makeTestStore()
+		require.Nil(t, err)
+		stw.ChunkSize = 100
+		if ns != nil {
+			stw.WithNamespace(ns)
+		}
+
+		key := []byte("Ezekiel")
+		values := []string{sampleSmall, sampleLarge[:1000], sampleLarge, sampleLarge[500:], sampleSmall + sampleSmall}
+
+		// the first value is kept as a version once versioning is enabled
+		_, err = stw.SetW(key, []byte(values[0]))
+		require.Nil(t, err)
+		stw.WithVersions(2)
+		for _, v := range values[1:] {
+			_, err = stw.SetW(key, []byte(v))
+			require.Nil(t, err)
+		}
+
+		versions, err := stw.ListVersionsW(key)
+		require.Nil(t, err)
+		require.Equal(t, []uint64{1, 2, 3, 4, 5}, versions)
+		for i, v := range values {
+			res, err := stw.GetVersionW(key, uint64(i+1))
+			require.Nil(t, err)
+			require.Equal(t, v, string(res))
+		}
+		res, err := stw.GetW(key)
+		require.Nil(t, err)
+		require.Equal(t, values[4], string(res))
+
+		// version 2 has 10 chunks and a record
+		pruned, err := stw.PruneVersionsW(key, 5)
+		require.Nil(t, err)
+		require.Equal(t, 1, pruned)
+		_, err = stw.PruneVersionsW(key, 5)
+		require.Equal(t, storewrapper.ErrPruneBudget, err)
+		pruned, err = stw.PruneVersionsW(key, 100)
+		require.Nil(t, err)
+		require.Equal(t, 1, pruned)
+		pruned, err = stw.PruneVersionsW(key, 100)
+		require.Nil(t, err)
+		require.Zero(t, pruned)
+
+		versions, err = stw.ListVersionsW(key)
+		require.Nil(t, err)
+		require.Equal(t, []uint64{3, 4, 5}, versions)
+		_, err = stw.GetVersionW(key, 2)
+		require.Equal(t, storewrapper.ErrVersionNotFound, err)
+		res, err = stw.GetVersionW(key, 3)
+		require.Nil(t, err)
+		require.Equal(t, values[2], string(res))
+
+		_, err = stw.DeleteW(key)
+		require.Nil(t, err)
+		it := stw.KVStore.Iterator(nil, nil)
+		require.False(t, it.Valid())
+		it.Close()
+	}
+}
+
+func TestKVStoreVersionsNegativeRetain(t *testing.T) {
+	stw, err := makeTestStore()
+	require.Nil(t, err)
+	require.Zero(t, stw.WithVersions(-1).RetainVersions)
+
+	key := []byte("Ezekiel")
+	for _, v := range []string{sampleSmall, sampleLarge, sampleSmall} {
+		_, err = stw.SetW(key, []byte(v))
+		require.Nil(t, err)
+	}
+
+	// a negative number set directly keeps no versions either
+	stw.RetainVersions = -5
+	pruned, err := stw.PruneVersionsW(key, 100)
+	require.Nil(t, err)
+	require.Equal(t, 2, pruned)
+
+	versions, err := stw.ListVersionsW(key)
+	require.Nil(t, err)
+	require.Equal(t, []uint64{3}, versions)
+}